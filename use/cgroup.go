@@ -0,0 +1,482 @@
+package use
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+	"github.com/pkg/errors"
+)
+
+// cgroupMetrics are names of metrics published for every cgroup by resource
+var cgroupMetrics = []struct {
+	resource string
+	name     string
+}{
+	{"compute", "utilization"},
+	{"compute", "saturation"},
+	{"compute", "throttled_time"},
+	{"memory", "utilization"},
+	{"memory", "usage"},
+	{"memory", "limit"},
+	{"memory", "errors"},
+	{"storage", "read_throughput"},
+	{"storage", "write_throughput"},
+	{"storage", "read_iops"},
+	{"storage", "write_iops"},
+}
+
+// cgroupUnlimited is the lowest value cgroup v1 reports for memory without
+// limit, the exact value depends on page size
+const cgroupUnlimited = int64(1) << 62
+
+// cgroupFS reads statistics of cgroup v1 or v2 (unified) hierarchy
+type cgroupFS struct {
+	root    string
+	unified bool
+}
+
+// newCgroupFS detects version of cgroup hierarchy mounted at root,
+// cgroup v2 root contains cgroup.controllers file
+func newCgroupFS(root string) *cgroupFS {
+	_, err := os.Stat(filepath.Join(root, "cgroup.controllers"))
+	return &cgroupFS{root: root, unified: err == nil}
+}
+
+// dir returns directory of cgroup in hierarchy of controller,
+// cgroup v2 has single hierarchy for all controllers
+func (c *cgroupFS) dir(controller string, cgroup string) string {
+	if c.unified {
+		return filepath.Join(c.root, cgroup)
+	}
+	return filepath.Join(c.root, controller, cgroup)
+}
+
+// list returns paths of cgroups relative to hierarchy root up to maxDepth
+// levels deep, cgroup v1 tree is read from cpu hierarchy
+func (c *cgroupFS) list(maxDepth int) ([]string, error) {
+	base, err := filepath.EvalSymlinks(c.dir("cpu", ""))
+	if err != nil {
+		return nil, errors.Errorf("Unable to find cgroup hierarchy in %s: %s", c.root, err.Error())
+	}
+	cgroups := []string{}
+	err = filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == base {
+				return err
+			}
+			log.Debugf("Skipping cgroup %s: %s", path, err.Error())
+			return nil
+		}
+		if !info.IsDir() || path == base {
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		if strings.Count(rel, string(filepath.Separator)) >= maxDepth {
+			return filepath.SkipDir
+		}
+		cgroups = append(cgroups, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cgroups, nil
+}
+
+// hasResource checks if statistics files of resource exist for cgroup,
+// controllers may be disabled for part of hierarchy
+func (c *cgroupFS) hasResource(cgroup string, resource string) bool {
+	files := map[string][]string{
+		"compute": {"cpuacct/cpuacct.usage", "cpu/cpu.stat"},
+		"memory":  {"memory/memory.usage_in_bytes", "memory/memory.limit_in_bytes"},
+		"storage": {"blkio/blkio.throttle.io_service_bytes", "blkio/blkio.throttle.io_serviced"},
+	}
+	if c.unified {
+		files = map[string][]string{
+			"compute": {"cpu/cpu.stat"},
+			"memory":  {"memory/memory.current", "memory/memory.max"},
+			"storage": {"io/io.stat"},
+		}
+	}
+	for _, file := range files[resource] {
+		controller, name := filepath.Split(file)
+		if _, err := os.Stat(filepath.Join(c.dir(filepath.Clean(controller), cgroup), name)); err != nil {
+			return false
+		}
+	}
+	return len(files[resource]) > 0
+}
+
+// readCPU returns cpu usage and throttling counters of cgroup, times are in nanoseconds
+func (c *cgroupFS) readCPU(cgroup string) (map[string]int64, error) {
+	counters := map[string]int64{"usage": 0, "periods": 0, "throttled": 0, "throttled_time": 0}
+	if c.unified {
+		stat, err := readKeyValues(filepath.Join(c.dir("cpu", cgroup), "cpu.stat"))
+		if err != nil {
+			return nil, err
+		}
+		counters["usage"] = stat["usage_usec"] * 1000
+		counters["periods"] = stat["nr_periods"]
+		counters["throttled"] = stat["nr_throttled"]
+		counters["throttled_time"] = stat["throttled_usec"] * 1000
+		return counters, nil
+	}
+
+	usage, err := readString(filepath.Join(c.dir("cpuacct", cgroup), "cpuacct.usage"))
+	if err != nil {
+		return nil, err
+	}
+	counters["usage"], err = strconv.ParseInt(usage, 10, 64)
+	if err != nil {
+		return nil, errors.Errorf("Unable to parse cpu usage of cgroup %s: %s", cgroup, err.Error())
+	}
+	stat, err := readKeyValues(filepath.Join(c.dir("cpu", cgroup), "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	counters["periods"] = stat["nr_periods"]
+	counters["throttled"] = stat["nr_throttled"]
+	counters["throttled_time"] = stat["throttled_time"]
+	return counters, nil
+}
+
+// readCPULimit returns number of CPUs cgroup is allowed to use by CFS
+// quota, 0 is returned for cgroup without quota
+func (c *cgroupFS) readCPULimit(cgroup string) (float64, error) {
+	var quota, period string
+	if c.unified {
+		// cpu.max exists only when cpu controller is enabled for cgroup,
+		// usage is accounted in cpu.stat regardless of it
+		if _, err := os.Stat(filepath.Join(c.dir("cpu", cgroup), "cpu.max")); os.IsNotExist(err) {
+			return 0, nil
+		}
+		limit, err := readString(filepath.Join(c.dir("cpu", cgroup), "cpu.max"))
+		if err != nil {
+			return 0, err
+		}
+		fields := strings.Fields(limit)
+		if len(fields) != 2 {
+			return 0, errors.Errorf("Unexpected format of cpu.max of cgroup %s", cgroup)
+		}
+		quota, period = fields[0], fields[1]
+	} else {
+		var err error
+		quota, err = readString(filepath.Join(c.dir("cpu", cgroup), "cpu.cfs_quota_us"))
+		if err != nil {
+			return 0, err
+		}
+		period, err = readString(filepath.Join(c.dir("cpu", cgroup), "cpu.cfs_period_us"))
+		if err != nil {
+			return 0, err
+		}
+	}
+	if quota == "max" || quota == "-1" {
+		return 0, nil
+	}
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil {
+		return 0, errors.Errorf("Unable to parse cpu quota of cgroup %s: %s", cgroup, err.Error())
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil {
+		return 0, errors.Errorf("Unable to parse cpu period of cgroup %s: %s", cgroup, err.Error())
+	}
+	return ratio(q, p), nil
+}
+
+// readMemory returns memory usage and limit in bytes and number of OOM kills
+// of cgroup, limit is 0 for cgroup without memory limit
+func (c *cgroupFS) readMemory(cgroup string) (map[string]int64, error) {
+	dir := c.dir("memory", cgroup)
+	usageFile, limitFile, eventsFile := "memory.usage_in_bytes", "memory.limit_in_bytes", "memory.oom_control"
+	if c.unified {
+		usageFile, limitFile, eventsFile = "memory.current", "memory.max", "memory.events"
+	}
+	stat := map[string]int64{"oom_kill": 0}
+
+	usage, err := readString(filepath.Join(dir, usageFile))
+	if err != nil {
+		return nil, err
+	}
+	stat["usage"], err = strconv.ParseInt(usage, 10, 64)
+	if err != nil {
+		return nil, errors.Errorf("Unable to parse memory usage of cgroup %s: %s", cgroup, err.Error())
+	}
+	limit, err := readString(filepath.Join(dir, limitFile))
+	if err != nil {
+		return nil, err
+	}
+	if limit != "max" {
+		stat["limit"], err = strconv.ParseInt(limit, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("Unable to parse memory limit of cgroup %s: %s", cgroup, err.Error())
+		}
+	}
+	if stat["limit"] >= cgroupUnlimited {
+		stat["limit"] = 0
+	}
+	// oom_kill counter is not reported by kernels older than 4.13
+	if events, err := readKeyValues(filepath.Join(dir, eventsFile)); err == nil {
+		stat["oom_kill"] = events["oom_kill"]
+	}
+	return stat, nil
+}
+
+// readIO returns bytes and operations read and written by cgroup summed over all devices
+func (c *cgroupFS) readIO(cgroup string) (map[string]int64, error) {
+	counters := map[string]int64{"read_bytes": 0, "write_bytes": 0, "read_ios": 0, "write_ios": 0}
+	if c.unified {
+		lines, err := readLines(filepath.Join(c.dir("io", cgroup), "io.stat"))
+		if err != nil {
+			return nil, err
+		}
+		keys := map[string]string{"rbytes": "read_bytes", "wbytes": "write_bytes", "rios": "read_ios", "wios": "write_ios"}
+		for _, line := range lines {
+			for _, field := range strings.Fields(line) {
+				kv := strings.SplitN(field, "=", 2)
+				if len(kv) != 2 || keys[kv[0]] == "" {
+					continue
+				}
+				value, err := strconv.ParseInt(kv[1], 10, 64)
+				if err != nil {
+					return nil, errors.Errorf("Unable to parse io.stat of cgroup %s: %s", cgroup, err.Error())
+				}
+				counters[keys[kv[0]]] += value
+			}
+		}
+		return counters, nil
+	}
+
+	for file, suffix := range map[string]string{"blkio.throttle.io_service_bytes": "_bytes", "blkio.throttle.io_serviced": "_ios"} {
+		lines, err := readLines(filepath.Join(c.dir("blkio", cgroup), file))
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			fields := strings.Fields(line)
+			if len(fields) != 3 || (fields[1] != "Read" && fields[1] != "Write") {
+				continue
+			}
+			value, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return nil, errors.Errorf("Unable to parse %s of cgroup %s: %s", file, cgroup, err.Error())
+			}
+			counters[strings.ToLower(fields[1])+suffix] += value
+		}
+	}
+	return counters, nil
+}
+
+// readKeyValues parses files with "key value" lines, e.g. cpu.stat or memory.events
+func readKeyValues(filename string) (map[string]int64, error) {
+	lines, err := readLines(filename)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]int64{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("Unable to parse %s in %s: %s", fields[0], filename, err.Error())
+		}
+		values[fields[0]] = value
+	}
+	return values, nil
+}
+
+// CgroupStat contains cgroup counters of previous and current measurement
+type CgroupStat struct {
+	last    map[string]int64
+	current map[string]int64
+	elapsed time.Duration
+	// cpus is number of CPUs available to cgroup
+	cpus float64
+}
+
+// CPUUtilization returns percentage of CPUs available to cgroup which
+// were used between last and current measurement
+func (c *CgroupStat) CPUUtilization() float64 {
+	return 100.0 * ratio(c.delta("usage"), float64(c.elapsed.Nanoseconds())*c.cpus)
+}
+
+// CPUSaturation returns percentage of CFS periods in which cgroup was throttled
+func (c *CgroupStat) CPUSaturation() float64 {
+	return 100.0 * ratio(c.delta("throttled"), c.delta("periods"))
+}
+
+// ThrottledTime returns time cgroup was throttled per second
+func (c *CgroupStat) ThrottledTime() float64 {
+	return ratio(c.delta("throttled_time"), float64(c.elapsed.Nanoseconds()))
+}
+
+// Rate returns change of counter per second
+func (c *CgroupStat) Rate(key string) float64 {
+	return ratio(c.delta(key), c.elapsed.Seconds())
+}
+
+func (c *CgroupStat) delta(key string) float64 {
+	if c.current[key] < c.last[key] {
+		// counter was reset, e.g. cgroup was recreated
+		return 0.0
+	}
+	return float64(c.current[key] - c.last[key])
+}
+
+// cgroupNamespaceElement encodes cgroup path as single namespace element
+func cgroupNamespaceElement(cgroup string) string {
+	return url.PathEscape(cgroup)
+}
+
+// cgroupFromNamespace decodes cgroup path from namespace element
+func cgroupFromNamespace(element string) (string, error) {
+	return url.PathUnescape(element)
+}
+
+// getCgroupMetricTypes returns metrics of cgroups, metrics of resource are
+// published only for cgroups with statistics of its controller
+func getCgroupMetricTypes(cgroupPath string, maxDepth int, filter *deviceFilter) []plugin.Metric {
+	var mts []plugin.Metric
+	cgroups := newCgroupFS(cgroupPath)
+	list, err := cgroups.list(maxDepth)
+	if err != nil {
+		log.Infof("Skipping cgroup metrics: %s", err.Error())
+		return mts
+	}
+	for _, cgroup := range filter.filter(list) {
+		for _, m := range cgroupMetrics {
+			if !cgroups.hasResource(cgroup, m.resource) {
+				continue
+			}
+			mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "cgroup", cgroupNamespaceElement(cgroup), m.resource, m.name)})
+		}
+	}
+	return mts
+}
+
+func isCgroupMetric(resource string, name string) bool {
+	for _, m := range cgroupMetrics {
+		if m.resource == resource && m.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// cgroupTags returns tags identifying cgroup of metric including
+// kubernetes pod and container of cgroups created by kubelet
+func (u *Use) cgroupTags(cgroup string) map[string]string {
+	tags := map[string]string{"cgroup_path": "/" + cgroup}
+	for k, v := range u.kubeTags(cgroup) {
+		tags[k] = v
+	}
+	return tags
+}
+
+func (u *Use) cgroupStat(ns plugin.Namespace) (*plugin.Metric, error) {
+	if len(ns) != 6 || !isCgroupMetric(ns.Strings()[4], ns.Strings()[5]) {
+		return nil, errors.Errorf("Unknown cgroup stat namespace %v", ns)
+	}
+	cgroup, err := cgroupFromNamespace(ns.Strings()[3])
+	if err != nil {
+		return nil, errors.Errorf("Invalid cgroup %s: %s", ns.Strings()[3], err.Error())
+	}
+	metric, err := u.readCgroupMetric(ns, cgroup, ns.Strings()[4], ns.Strings()[5])
+	if err != nil {
+		return nil, err
+	}
+	return &plugin.Metric{
+		Namespace: ns,
+		Data:      metric,
+		Tags:      u.cgroupTags(cgroup),
+	}, nil
+}
+
+// readCgroupMetric returns metric of cgroup resource, counters are kept
+// in snapshot of namespace
+func (u *Use) readCgroupMetric(ns plugin.Namespace, cgroup string, resource string, name string) (float64, error) {
+	cgroups := newCgroupFS(u.CgroupPath)
+	var metric float64
+	switch resource {
+	case "compute":
+		current, err := cgroups.readCPU(cgroup)
+		if err != nil {
+			return 0, errors.Errorf("Unable to get cgroup cpu stat: %s", err.Error())
+		}
+		cpus, err := cgroups.readCPULimit(cgroup)
+		if err != nil {
+			return 0, errors.Errorf("Unable to get cgroup cpu limit: %s", err.Error())
+		}
+		if cpus == 0 {
+			count, err := onlineCPUs(u.CpuStatPath, u.CpuSysPath)
+			if err != nil {
+				return 0, errors.Errorf("Unable to get cgroup cpu limit: %s", err.Error())
+			}
+			cpus = float64(count)
+		}
+		last, elapsed, ok := u.swapSnapshot(ns, current)
+		if !ok {
+			break
+		}
+		cgroupStat := CgroupStat{last: last.counters, current: current, elapsed: elapsed, cpus: cpus}
+		switch name {
+		case "utilization":
+			metric = cgroupStat.CPUUtilization()
+		case "saturation":
+			metric = cgroupStat.CPUSaturation()
+		case "throttled_time":
+			metric = cgroupStat.ThrottledTime()
+		}
+	case "memory":
+		stat, err := cgroups.readMemory(cgroup)
+		if err != nil {
+			return 0, errors.Errorf("Unable to get cgroup memory stat: %s", err.Error())
+		}
+		switch name {
+		case "utilization":
+			limit := stat["limit"]
+			if limit == 0 {
+				memInfo, err := readStatForMemInfo(u.MemInfoPath)
+				if err != nil {
+					return 0, errors.Errorf("Unable to get cgroup memory limit: %s", err.Error())
+				}
+				limit = memInfo["MemTotal"] * 1024
+			}
+			metric = 100.0 * ratio(float64(stat["usage"]), float64(limit))
+		case "usage":
+			metric = float64(stat["usage"])
+		case "limit":
+			metric = float64(stat["limit"])
+		case "errors":
+			metric = float64(stat["oom_kill"])
+		}
+	case "storage":
+		key := map[string]string{
+			"read_throughput":  "read_bytes",
+			"write_throughput": "write_bytes",
+			"read_iops":        "read_ios",
+			"write_iops":       "write_ios",
+		}[name]
+		current, err := cgroups.readIO(cgroup)
+		if err != nil {
+			return 0, errors.Errorf("Unable to get cgroup io stat: %s", err.Error())
+		}
+		if last, elapsed, ok := u.swapSnapshot(ns, current); ok {
+			cgroupStat := CgroupStat{last: last.counters, current: current, elapsed: elapsed}
+			metric = cgroupStat.Rate(key)
+		}
+	}
+	return metric, nil
+}
@@ -0,0 +1,150 @@
+//
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package use
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCgroup(t *testing.T) {
+	Convey("Cgroup v2 hierarchy should be detected and listed", t, func() {
+		c := newCgroupFS("sys/fs/cgroup")
+		So(c.unified, ShouldBeTrue)
+		cgroups, err := c.list(4)
+		So(err, ShouldBeNil)
+		So(cgroups, ShouldResemble, []string{
+			"init.scope",
+			"system.slice",
+			"system.slice/docker-0123.scope",
+			"system.slice/sshd.service",
+			"user.slice",
+			"user.slice/user-1000.slice",
+			"user.slice/user-1000.slice/user@1000.service",
+			"user.slice/user-1000.slice/user@1000.service/app.slice",
+		})
+		cgroups, err = c.list(1)
+		So(err, ShouldBeNil)
+		So(cgroups, ShouldResemble, []string{"init.scope", "system.slice", "user.slice"})
+	})
+	Convey("Missing cgroup hierarchy should return error", t, func() {
+		_, err := newCgroupFS("/some/cgroup").list(4)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Cgroup v2 statistics should be read", t, func() {
+		c := newCgroupFS("sys/fs/cgroup")
+		cpu, err := c.readCPU("system.slice/docker-0123.scope")
+		So(err, ShouldBeNil)
+		So(cpu, ShouldResemble, map[string]int64{"usage": 2000000000, "periods": 100, "throttled": 25, "throttled_time": 300000000})
+		cpus, err := c.readCPULimit("system.slice/docker-0123.scope")
+		So(err, ShouldBeNil)
+		So(cpus, ShouldEqual, 2.0)
+		cpus, err = c.readCPULimit("system.slice")
+		So(err, ShouldBeNil)
+		So(cpus, ShouldEqual, 0.0)
+		mem, err := c.readMemory("system.slice/docker-0123.scope")
+		So(err, ShouldBeNil)
+		So(mem, ShouldResemble, map[string]int64{"usage": 536870912, "limit": 1073741824, "oom_kill": 1})
+		mem, err = c.readMemory("system.slice")
+		So(err, ShouldBeNil)
+		So(mem["limit"], ShouldEqual, 0)
+		io, err := c.readIO("system.slice/docker-0123.scope")
+		So(err, ShouldBeNil)
+		So(io, ShouldResemble, map[string]int64{"read_bytes": 2097152, "write_bytes": 2097152, "read_ios": 128, "write_ios": 200})
+	})
+	Convey("Cgroup v1 statistics should be read", t, func() {
+		c := newCgroupFS("cgroupv1")
+		So(c.unified, ShouldBeFalse)
+		cgroups, err := c.list(4)
+		So(err, ShouldBeNil)
+		So(cgroups, ShouldResemble, []string{"docker", "docker/0123"})
+		cpu, err := c.readCPU("docker/0123")
+		So(err, ShouldBeNil)
+		So(cpu, ShouldResemble, map[string]int64{"usage": 4000000000, "periods": 100, "throttled": 10, "throttled_time": 500000000})
+		cpus, err := c.readCPULimit("docker/0123")
+		So(err, ShouldBeNil)
+		So(cpus, ShouldEqual, 0.5)
+		mem, err := c.readMemory("docker/0123")
+		So(err, ShouldBeNil)
+		So(mem, ShouldResemble, map[string]int64{"usage": 268435456, "limit": 0, "oom_kill": 2})
+		io, err := c.readIO("docker/0123")
+		So(err, ShouldBeNil)
+		So(io, ShouldResemble, map[string]int64{"read_bytes": 4096, "write_bytes": 8192, "read_ios": 1, "write_ios": 2})
+	})
+	Convey("Cgroup resources should be detected by statistics files", t, func() {
+		c := newCgroupFS("sys/fs/cgroup")
+		So(c.hasResource("init.scope", "compute"), ShouldBeTrue)
+		So(c.hasResource("init.scope", "memory"), ShouldBeFalse)
+		So(c.hasResource("user.slice", "compute"), ShouldBeFalse)
+		So(c.hasResource("system.slice/sshd.service", "storage"), ShouldBeTrue)
+		c = newCgroupFS("cgroupv1")
+		So(c.hasResource("docker/0123", "compute"), ShouldBeTrue)
+		So(c.hasResource("docker/0123", "memory"), ShouldBeTrue)
+		So(c.hasResource("docker/0123", "storage"), ShouldBeTrue)
+		So(c.hasResource("docker", "compute"), ShouldBeFalse)
+	})
+	Convey("Cgroup without cpu controller should have no cpu limit", t, func() {
+		c := newCgroupFS("sys/fs/cgroup")
+		cpus, err := c.readCPULimit("init.scope")
+		So(err, ShouldBeNil)
+		So(cpus, ShouldEqual, 0)
+		cpu, err := c.readCPU("init.scope")
+		So(err, ShouldBeNil)
+		So(cpu["usage"], ShouldEqual, 81234567000)
+	})
+	Convey("Cgroup cpu metrics should be computed between measurements", t, func() {
+		c := CgroupStat{
+			last:    map[string]int64{"usage": 1000000000, "periods": 100, "throttled": 10, "throttled_time": 0},
+			current: map[string]int64{"usage": 2000000000, "periods": 120, "throttled": 15, "throttled_time": 500000000},
+			elapsed: 2 * time.Second,
+			cpus:    2,
+		}
+		So(c.CPUUtilization(), ShouldEqual, 25.0)
+		So(c.CPUSaturation(), ShouldEqual, 25.0)
+		So(c.ThrottledTime(), ShouldEqual, 0.25)
+		So(c.Rate("periods"), ShouldEqual, 10.0)
+	})
+	Convey("Cgroup path should round trip through namespace element", t, func() {
+		element := cgroupNamespaceElement("kubepods/burstable/pod1")
+		So(element, ShouldEqual, "kubepods%2Fburstable%2Fpod1")
+		cgroup, err := cgroupFromNamespace(element)
+		So(err, ShouldBeNil)
+		So(cgroup, ShouldEqual, "kubepods/burstable/pod1")
+	})
+	Convey("Cgroup metric types should be published for filtered cgroups", t, func() {
+		filter := &deviceFilter{}
+		mts := getCgroupMetricTypes("sys/fs/cgroup", 4, filter)
+		// init.scope has only cpu.stat, slices of user.slice have no statistics
+		So(len(mts), ShouldEqual, 3*len(cgroupMetrics)+3)
+		So(mts[0].Namespace.String(), ShouldEqual, "/intel/use/cgroup/init.scope/compute/utilization")
+		So(mts[3].Namespace.String(), ShouldEqual, "/intel/use/cgroup/system.slice/compute/utilization")
+		filter, err := newDeviceFilter(plugin.Config{"cgroup_include": "docker"}, "cgroup", "sys/fs/cgroup")
+		So(err, ShouldBeNil)
+		mts = getCgroupMetricTypes("sys/fs/cgroup", 4, filter)
+		So(len(mts), ShouldEqual, len(cgroupMetrics))
+		So(getCgroupMetricTypes("/some/cgroup", 4, filter), ShouldBeEmpty)
+	})
+}
@@ -21,82 +21,226 @@ package use
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
 	"github.com/pkg/errors"
-	"github.com/shirou/gopsutil/cpu"
 )
 
+// cpuStatEntries are names of /proc/stat cpu columns
+var cpuStatEntries = []string{"user", "nice", "system", "idle", "iowait", "irq", "softirq", "steal", "guest", "guest_nice"}
+
+// perCPUMetricLabels are names of metrics published for every CPU
+// in addition to time spent in each of cpuStatEntries
+var perCPUMetricLabels = []string{
+	"utilization",
+	"saturation",
+}
+
 // CPUStat contains values of CPU previous measurments
 type CPUStat struct {
-	last        map[string]int64
-	current     map[string]int64
-	cpuStatPath string
+	last    map[string]int64
+	current map[string]int64
+	elapsed time.Duration
 }
 
 // LoadAvg struct with Host Load Statistics
 type LoadAvg struct {
-	Load1  float64
-	Load5  float64
-	Load15 float64
+	Load1   float64
+	Load5   float64
+	Load15  float64
+	Running int64
+	Total   int64
 }
 
-// Utilization returns utilization of CPU
-func (c *CPUStat) Utilization() (float64, error) {
-	var err error
+// runQueueMetrics are compute saturation metrics normalized by number of CPUs
+var runQueueMetrics = []string{
+	"procs_running_per_cpu",
+	"procs_blocked_per_cpu",
+	"runnable_per_cpu",
+}
 
-	c.last, err = readCPUStat(c.cpuStatPath)
-	if err != nil {
-		return 0.0, errors.Errorf("Unable to read cpu stat: " + err.Error())
-	}
-	time.Sleep(waitTime)
-	c.current, err = readCPUStat(c.cpuStatPath)
-	if err != nil {
-		return 0.0, errors.Errorf("Unable to read cpu stat: " + err.Error())
-	}
+// loadAvgMetrics are names of metrics published from /proc/loadavg
+var loadAvgMetrics = []string{
+	"load1",
+	"load5",
+	"load15",
+	"load1_per_cpu",
+	"load5_per_cpu",
+	"load15_per_cpu",
+	"entities_running",
+	"entities_total",
+}
+
+// Utilization returns utilization of CPU between last and current measurement
+func (c *CPUStat) Utilization() float64 {
 	deltaIdle := c.Idle(true) - c.Idle(false)
 	deltaNonIdle := c.NonIdle(true) - c.NonIdle(false)
-	if deltaIdle == 0.0 || deltaNonIdle == 0.0 {
-		return 0.0, nil
+	if deltaIdle+deltaNonIdle <= 0.0 || deltaNonIdle < 0.0 {
+		return 0.0
 	}
 
-	return 100.00 * (deltaNonIdle / (deltaIdle + deltaNonIdle)), nil
-
+	return 100.00 * (deltaNonIdle / (deltaIdle + deltaNonIdle))
 }
 
-// Idle returns current or last Idle time
+// Idle returns current or last Idle time, including time spent waiting for I/O
 func (c *CPUStat) Idle(actual bool) float64 {
 	if actual {
-		return float64(c.current["idle"])
+		return float64(c.current["idle"] + c.current["iowait"])
 	}
-	return float64(c.last["idle"])
+	return float64(c.last["idle"] + c.last["iowait"])
 }
 
-// NonIdle returns current or last NonIdle time
+// NonIdle returns current or last NonIdle time, including time spent
+// serving interrupts and stolen by hypervisor
 func (c *CPUStat) NonIdle(actual bool) float64 {
+	stat := c.last
 	if actual {
-		return float64(c.current["user"] + c.current["nice"] + c.current["system"])
+		stat = c.current
+	}
+	return float64(stat["user"] + stat["nice"] + stat["system"] + stat["irq"] + stat["softirq"] + stat["steal"])
+}
+
+// State returns percentage of time CPU spent in given state between last and current measurement
+func (c *CPUStat) State(state string) float64 {
+	deltaTotal := c.Total(true) - c.Total(false)
+	delta := float64(c.current[state] - c.last[state])
+	if deltaTotal <= 0.0 || delta < 0.0 {
+		return 0.0
+	}
+	return 100.0 * delta / deltaTotal
+}
+
+// Total returns current or last time spent in all states, guest time
+// is already accounted in user and nice
+func (c *CPUStat) Total(actual bool) float64 {
+	stat := c.last
+	if actual {
+		stat = c.current
+	}
+	var total int64
+	for _, state := range cpuStatEntries {
+		if state == "guest" || state == "guest_nice" {
+			continue
+		}
+		total += stat[state]
+	}
+	return float64(total)
+}
+
+// Value returns load average metric of given name, metrics with
+// _per_cpu suffix are divided by number of CPUs
+func (l *LoadAvg) Value(name string, cpuCount int) (float64, error) {
+	var value float64
+	switch strings.TrimSuffix(name, "_per_cpu") {
+	case "load1":
+		value = l.Load1
+	case "load5":
+		value = l.Load5
+	case "load15":
+		value = l.Load15
+	case "entities_running":
+		return float64(l.Running), nil
+	case "entities_total":
+		return float64(l.Total), nil
+	default:
+		return 0, errors.Errorf("Unknown load average metric %s", name)
+	}
+	if strings.HasSuffix(name, "_per_cpu") {
+		if cpuCount <= 0 {
+			return 0, errors.Errorf("Unknown number of CPUs")
+		}
+		value /= float64(cpuCount)
 	}
-	return float64(c.last["user"] + c.last["nice"] + c.last["system"])
+	return value, nil
+}
+
+// RunQueueWait returns average number of tasks waiting on CPU run queue
+// between last and current measurement of /proc/schedstat
+func (c *CPUStat) RunQueueWait() float64 {
+	if c.elapsed <= 0 || c.current["run_delay"] < c.last["run_delay"] {
+		return 0.0
+	}
+	return float64(c.current["run_delay"]-c.last["run_delay"]) / float64(c.elapsed.Nanoseconds())
 }
 
 func (p *Use) computeStat(ns plugin.Namespace) (*plugin.Metric, error) {
 	switch {
+	case regexp.MustCompile(`^/intel/use/compute/cpu/[0-9]+/`).MatchString(ns.String()):
+		return p.perCPUStat(ns)
+	case regexp.MustCompile(`^/intel/use/compute/topology/`).MatchString(ns.String()):
+		return p.topologyStat(ns)
 	case regexp.MustCompile(`^/intel/use/compute/utilization`).MatchString(ns.String()):
-		cpuStat := CPUStat{cpuStatPath: p.CpuStatPath}
-		metric, err := cpuStat.Utilization()
+		current, err := readCPUStat(p.CpuStatPath)
 		if err != nil {
 			return nil, errors.Errorf("Unable to get cpu stat utilization: " + err.Error())
 		}
+		var metric float64
+		if last, _, ok := p.swapSnapshot(ns, current); ok {
+			cpuStat := CPUStat{last: last.counters, current: current}
+			metric = cpuStat.Utilization()
+		}
+		return &plugin.Metric{
+			Namespace: ns,
+			Data:      metric,
+		}, nil
+	case regexp.MustCompile(`^/intel/use/compute/(user|nice|system|idle|iowait|irq|softirq|steal|guest|guest_nice)$`).MatchString(ns.String()):
+		current, err := readCPUStat(p.CpuStatPath)
+		if err != nil {
+			return nil, errors.Errorf("Unable to get cpu stat %s: %s", ns.Strings()[len(ns)-1], err.Error())
+		}
+		var metric float64
+		if last, _, ok := p.swapSnapshot(ns, current); ok {
+			cpuStat := CPUStat{last: last.counters, current: current}
+			metric = cpuStat.State(ns.Strings()[len(ns)-1])
+		}
+		return &plugin.Metric{
+			Namespace: ns,
+			Data:      metric,
+		}, nil
+	case regexp.MustCompile(`^/intel/use/compute/(procs_running|procs_blocked|runnable)_per_cpu$`).MatchString(ns.String()):
+		cpuCount, err := onlineCPUs(p.CpuStatPath, p.CpuSysPath)
+		if err != nil {
+			return nil, errors.Errorf("Unable to get cpu %s: %s", ns.Strings()[len(ns)-1], err.Error())
+		}
+		metric, err := getRunQueueSaturation(ns.Strings()[len(ns)-1], p.CpuStatPath, p.LoadAvgPath, cpuCount)
+		if err != nil {
+			return nil, errors.Errorf("Unable to get cpu %s: %s", ns.Strings()[len(ns)-1], err.Error())
+		}
+		return &plugin.Metric{
+			Namespace: ns,
+			Data:      metric,
+		}, nil
+	case regexp.MustCompile(`^/intel/use/compute/(load1|load5|load15)(_per_cpu)?$|^/intel/use/compute/entities_(running|total)$`).MatchString(ns.String()):
+		load, err := readLoad(p.LoadAvgPath)
+		if err != nil {
+			return nil, errors.Errorf("Unable to get cpu %s: %s", ns.Strings()[len(ns)-1], err.Error())
+		}
+		var cpuCount int
+		if strings.HasSuffix(ns.String(), "_per_cpu") {
+			cpuCount, err = onlineCPUs(p.CpuStatPath, p.CpuSysPath)
+			if err != nil {
+				return nil, errors.Errorf("Unable to get cpu %s: %s", ns.Strings()[len(ns)-1], err.Error())
+			}
+		}
+		metric, err := load.Value(ns.Strings()[len(ns)-1], cpuCount)
+		if err != nil {
+			return nil, errors.Errorf("Unable to get cpu %s: %s", ns.Strings()[len(ns)-1], err.Error())
+		}
 		return &plugin.Metric{
 			Namespace: ns,
 			Data:      metric,
 		}, nil
 	case regexp.MustCompile(`^/intel/use/compute/saturation`).MatchString(ns.String()):
-		metric, err := getSaturation(p.LoadAvgPath)
+		cpuCount, err := onlineCPUs(p.CpuStatPath, p.CpuSysPath)
+		if err != nil {
+			return nil, errors.Errorf("Unable to get cpu saturation: " + err.Error())
+		}
+		metric, err := getSaturation(p.LoadAvgPath, cpuCount)
 		if err != nil {
 			return nil, errors.Errorf("Unable to get cpu saturation: " + err.Error())
 		}
@@ -104,25 +248,124 @@ func (p *Use) computeStat(ns plugin.Namespace) (*plugin.Metric, error) {
 			Namespace: ns,
 			Data:      metric,
 		}, nil
+	case regexp.MustCompile(`^/intel/use/compute/errors`).MatchString(ns.String()):
+		metric, err := readMachineCheckErrors(p.InterruptsPath)
+		if err != nil {
+			return nil, errors.Errorf("Unable to get cpu errors: " + err.Error())
+		}
+		return &plugin.Metric{
+			Namespace: ns,
+			Data:      float64(metric),
+		}, nil
 	}
 
 	return nil, fmt.Errorf("Unknown error processing %v", ns)
 }
 
-func getCPUMetricTypes() ([]plugin.Metric, error) {
+func (p *Use) perCPUStat(ns plugin.Namespace) (*plugin.Metric, error) {
+	cpuID := ns.Strings()[4]
+	name := ns.Strings()[len(ns)-1]
+	var metric float64
+	switch name {
+	case "saturation":
+		stats, err := readSchedStat(p.SchedStatPath)
+		if err != nil {
+			return nil, errors.Errorf("Unable to get cpu %s saturation: %s", cpuID, err.Error())
+		}
+		current, ok := stats[cpuID]
+		if !ok {
+			return nil, errors.Errorf("Unable to find cpu %s in %s", cpuID, p.SchedStatPath)
+		}
+		if last, elapsed, ok := p.swapSnapshot(ns, current); ok {
+			cpuStat := CPUStat{last: last.counters, current: current, elapsed: elapsed}
+			metric = cpuStat.RunQueueWait()
+		}
+	default:
+		stats, err := readCPUStats(p.CpuStatPath)
+		if err != nil {
+			return nil, errors.Errorf("Unable to get cpu %s %s: %s", cpuID, name, err.Error())
+		}
+		current, ok := stats["cpu"+cpuID]
+		if !ok {
+			return nil, errors.Errorf("Unable to find cpu %s in %s", cpuID, p.CpuStatPath)
+		}
+		if last, _, ok := p.swapSnapshot(ns, current); ok {
+			cpuStat := CPUStat{last: last.counters, current: current}
+			if name == "utilization" {
+				metric = cpuStat.Utilization()
+			} else {
+				metric = cpuStat.State(name)
+			}
+		}
+	}
+	return &plugin.Metric{
+		Namespace: ns,
+		Data:      metric,
+	}, nil
+}
+
+func getCPUMetricTypes(cpuStatPath string, schedStatPath string, cpuSysPath string) ([]plugin.Metric, error) {
 	var mts []plugin.Metric
 	for _, name := range metricLabels {
 		mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "compute", name)})
 	}
+	for _, name := range cpuStatEntries {
+		mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "compute", name)})
+	}
+	for _, name := range runQueueMetrics {
+		mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "compute", name)})
+	}
+	for _, name := range loadAvgMetrics {
+		mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "compute", name)})
+	}
+	mts = append(mts, getTopologyMetricTypes(cpuStatPath, cpuSysPath)...)
+
+	stats, err := readCPUStats(cpuStatPath)
+	if err != nil {
+		return nil, err
+	}
+	_, err = readSchedStat(schedStatPath)
+	schedStatAvailable := err == nil
+	if !schedStatAvailable {
+		log.Infof("Skipping per cpu saturation metrics: %s", err.Error())
+	}
+	for _, cpuID := range listCPUs(stats) {
+		for _, name := range perCPUMetricLabels {
+			if name == "saturation" && !schedStatAvailable {
+				continue
+			}
+			mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "compute", "cpu", cpuID, name)})
+		}
+		for _, name := range cpuStatEntries {
+			mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "compute", "cpu", cpuID, name)})
+		}
+	}
 	return mts, nil
 }
 
-func getSaturation(loadAvgPath string) (float64, error) {
-	cpus, err := cpu.Times(true)
-	if err != nil {
-		return 0, err
+// listCPUs returns sorted ids of CPUs reported by /proc/stat
+func listCPUs(stats map[string]map[string]int64) []string {
+	ids := []int{}
+	for name := range stats {
+		id, err := strconv.Atoi(strings.TrimPrefix(name, "cpu"))
+		if err != nil {
+			// aggregated cpu line
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	cpus := []string{}
+	for _, id := range ids {
+		cpus = append(cpus, strconv.Itoa(id))
+	}
+	return cpus
+}
+
+func getSaturation(loadAvgPath string, cpuCount int) (float64, error) {
+	if cpuCount <= 0 {
+		return 0, errors.Errorf("Unknown number of CPUs")
 	}
-	cpuCount := len(cpus)
 	load, err := readLoad(loadAvgPath)
 	if err != nil {
 		return 0, err
@@ -130,6 +373,32 @@ func getSaturation(loadAvgPath string) (float64, error) {
 	return load.Load1 / float64(cpuCount), nil
 }
 
+// getRunQueueSaturation returns number of running, blocked or runnable
+// tasks per CPU at the time of collection
+func getRunQueueSaturation(name string, cpuStatPath string, loadAvgPath string, cpuCount int) (float64, error) {
+	if cpuCount <= 0 {
+		return 0, errors.Errorf("Unknown number of CPUs")
+	}
+	var tasks int64
+	switch name {
+	case "procs_running_per_cpu", "procs_blocked_per_cpu":
+		procs, err := readProcsStat(cpuStatPath)
+		if err != nil {
+			return 0, err
+		}
+		tasks = procs[strings.TrimSuffix(name, "_per_cpu")]
+	case "runnable_per_cpu":
+		load, err := readLoad(loadAvgPath)
+		if err != nil {
+			return 0, err
+		}
+		tasks = load.Running
+	default:
+		return 0, errors.Errorf("Unknown run queue metric %s", name)
+	}
+	return float64(tasks) / float64(cpuCount), nil
+}
+
 func readLoad(loadAvgPath string) (*LoadAvg, error) {
 	filename := loadAvgPath
 	lines, err := readLines(filename)
@@ -138,9 +407,24 @@ func readLoad(loadAvgPath string) (*LoadAvg, error) {
 		return load, err
 	}
 	fields := strings.Fields(lines[0])
-	load.Load1, err = strconv.ParseFloat((fields[0]), 64)
-	load.Load5, err = strconv.ParseFloat((fields[1]), 64)
-	load.Load15, err = strconv.ParseFloat((fields[2]), 64)
+	if len(fields) < 4 {
+		return nil, errors.Errorf("Unexpected format of %s", loadAvgPath)
+	}
+	for i, value := range []*float64{&load.Load1, &load.Load5, &load.Load15} {
+		*value, err = strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	entities := strings.Split(fields[3], "/")
+	if len(entities) != 2 {
+		return nil, errors.Errorf("Unexpected format of scheduling entities %s", fields[3])
+	}
+	load.Running, err = strconv.ParseInt(entities[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	load.Total, err = strconv.ParseInt(entities[1], 10, 64)
 	if err != nil {
 		return nil, err
 	}
@@ -148,26 +432,122 @@ func readLoad(loadAvgPath string) (*LoadAvg, error) {
 	return load, nil
 }
 
+// readProcsStat returns procs_running and procs_blocked from /proc/stat
+func readProcsStat(cpuStatPath string) (map[string]int64, error) {
+	lines, err := readLines(cpuStatPath)
+	if err != nil {
+		return nil, err
+	}
+	procs := map[string]int64{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || (fields[0] != "procs_running" && fields[0] != "procs_blocked") {
+			continue
+		}
+		procs[fields[0]], err = strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("Unable to parse %s: %s", fields[0], err.Error())
+		}
+	}
+	if len(procs) != 2 {
+		return nil, errors.Errorf("Unable to find procs_running and procs_blocked in %s", cpuStatPath)
+	}
+	return procs, nil
+}
+
+// readMachineCheckErrors returns number of machine check exceptions summed over all CPUs
+func readMachineCheckErrors(interruptsPath string) (int64, error) {
+	lines, err := readLines(interruptsPath)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "MCE:" {
+			continue
+		}
+		var count int64
+		for _, field := range fields[1:] {
+			val, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				// per-CPU counters are followed by the description
+				break
+			}
+			count += val
+		}
+		return count, nil
+	}
+	// kernels without machine check support do not report MCE line
+	return 0, nil
+}
+
 func readCPUStat(cpuStatPath string) (map[string]int64, error) {
+	stats, err := readCPUStats(cpuStatPath)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := stats["cpu"]
+	if !ok {
+		return map[string]int64{}, errors.Errorf("Unable to find aggregated cpu stat in %s", cpuStatPath)
+	}
+
+	return values, nil
+}
+
+// readCPUStats returns aggregated and per CPU stats keyed by name of /proc/stat line, e.g. "cpu", "cpu0"
+func readCPUStats(cpuStatPath string) (map[string]map[string]int64, error) {
 	content, err := readLines(cpuStatPath)
 	if err != nil {
 		return nil, errors.Errorf("Unable to read lines from cpu stat path %s: %s", cpuStatPath, err.Error())
 	}
 
-	CPUStat := strings.Fields(content[0])
-	values, err := mapCPUStat(CPUStat)
-	if err != nil {
-		return map[string]int64{}, errors.Errorf("Unable to map cpu stat: %s", err.Error())
+	stats := map[string]map[string]int64{}
+	for _, line := range content {
+		CPUStat := strings.Fields(line)
+		if len(CPUStat) == 0 || !strings.HasPrefix(CPUStat[0], "cpu") {
+			continue
+		}
+		values, err := mapCPUStat(CPUStat)
+		if err != nil {
+			return nil, errors.Errorf("Unable to map cpu stat: %s", err.Error())
+		}
+		stats[CPUStat[0]] = values
 	}
 
-	return values, nil
+	return stats, nil
+}
+
+// readSchedStat returns time in nanoseconds tasks spent waiting on run queue
+// of each CPU, keyed by CPU id
+func readSchedStat(schedStatPath string) (map[string]map[string]int64, error) {
+	lines, err := readLines(schedStatPath)
+	if err != nil {
+		return nil, err
+	}
+	stats := map[string]map[string]int64{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 9 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		runDelay, err := strconv.ParseInt(fields[8], 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("Unable to parse run queue wait time of %s: %s", fields[0], err.Error())
+		}
+		stats[strings.TrimPrefix(fields[0], "cpu")] = map[string]int64{"run_delay": runDelay}
+	}
+	return stats, nil
 }
 
 func mapCPUStat(utilData []string) (map[string]int64, error) {
 	cpuStat := map[string]int64{}
-	entries := []string{"user", "nice", "system", "idle", "iowait", "irq", "softirq", "steal", "guest", "guest_nice"}
 
-	for i, entry := range entries {
+	for i, entry := range cpuStatEntries {
+		if i+1 >= len(utilData) {
+			// older kernels do not report steal and guest time
+			cpuStat[entry] = 0
+			continue
+		}
 		val, err := strconv.ParseInt(utilData[i+1], 10, 64)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,150 @@
+//
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package use
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestComputeUsePlugin(t *testing.T) {
+	Convey("Read cpu data should return proper aggregated values", t, func() {
+		stat, err := readCPUStat("proc/stat")
+		So(err, ShouldBeNil)
+		So(stat["user"], ShouldEqual, 2671)
+		So(stat["idle"], ShouldEqual, 19023517)
+	})
+	Convey("Read cpu data when file not available should return error", t, func() {
+		_, err := readCPUStat("/some/proc/stat")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("get Utilization should return proper value", t, func() {
+		c := CPUStat{
+			last:    map[string]int64{"user": 100, "nice": 0, "system": 50, "idle": 1000},
+			current: map[string]int64{"user": 130, "nice": 0, "system": 70, "idle": 1150},
+		}
+		So(c.Utilization(), ShouldResemble, 25.0)
+	})
+	Convey("get Utilization should account all cpu states", t, func() {
+		c := CPUStat{
+			last:    map[string]int64{"user": 100, "system": 50, "idle": 1000, "iowait": 10, "irq": 5, "softirq": 5, "steal": 0, "guest": 20},
+			current: map[string]int64{"user": 130, "system": 70, "idle": 1100, "iowait": 30, "irq": 10, "softirq": 10, "steal": 20, "guest": 30},
+		}
+		So(c.Utilization(), ShouldResemble, 40.0)
+		So(c.State("iowait"), ShouldResemble, 10.0)
+		So(c.State("steal"), ShouldResemble, 10.0)
+		So(c.State("guest"), ShouldResemble, 5.0)
+	})
+	Convey("get Utilization without elapsed time should return zero", t, func() {
+		c := CPUStat{
+			last:    map[string]int64{"user": 100, "idle": 1000},
+			current: map[string]int64{"user": 100, "idle": 1000},
+		}
+		So(c.Utilization(), ShouldResemble, 0.0)
+	})
+	Convey("Read machine check exceptions should sum all CPUs", t, func() {
+		mce, err := readMachineCheckErrors("proc/interrupts")
+		So(err, ShouldBeNil)
+		So(mce, ShouldEqual, 2)
+	})
+	Convey("Read cpu data should return per cpu values", t, func() {
+		stats, err := readCPUStats("proc/stat")
+		So(err, ShouldBeNil)
+		So(len(stats), ShouldEqual, 9)
+		So(stats["cpu1"]["nice"], ShouldEqual, 497)
+		So(stats["cpu7"]["iowait"], ShouldEqual, 1101)
+		So(listCPUs(stats), ShouldResemble, []string{"0", "1", "2", "3", "4", "5", "6", "7"})
+	})
+	Convey("Read schedstat should return run queue wait time per cpu", t, func() {
+		stats, err := readSchedStat("proc/schedstat")
+		So(err, ShouldBeNil)
+		So(len(stats), ShouldEqual, 8)
+		So(stats["0"]["run_delay"], ShouldEqual, 412339871)
+	})
+	Convey("get State should return percentage of time spent in state", t, func() {
+		c := CPUStat{
+			last:    map[string]int64{"user": 100, "system": 50, "idle": 1000, "iowait": 0},
+			current: map[string]int64{"user": 130, "system": 70, "idle": 1140, "iowait": 10},
+		}
+		So(c.State("user"), ShouldResemble, 15.0)
+		So(c.State("system"), ShouldResemble, 10.0)
+		So(c.State("idle"), ShouldResemble, 70.0)
+	})
+	Convey("get RunQueueWait should return average number of waiting tasks", t, func() {
+		c := CPUStat{
+			last:    map[string]int64{"run_delay": 412339871},
+			current: map[string]int64{"run_delay": 912339871},
+			elapsed: 2 * time.Second,
+		}
+		So(c.RunQueueWait(), ShouldResemble, 0.25)
+	})
+	Convey("Per cpu metric types should be published for every cpu", t, func() {
+		mts, err := getCPUMetricTypes("proc/stat", "proc/schedstat", "sys/devices/system/cpu")
+		So(err, ShouldBeNil)
+		So(len(mts), ShouldEqual, len(metricLabels)+len(cpuStatEntries)+len(runQueueMetrics)+len(loadAvgMetrics)+len(topologyMetrics)+8*(len(perCPUMetricLabels)+len(cpuStatEntries)))
+		So(mts[len(metricLabels)].Namespace.String(), ShouldEqual, "/intel/use/compute/user")
+		So(mts[len(metricLabels)+len(cpuStatEntries)+len(runQueueMetrics)+len(loadAvgMetrics)+len(topologyMetrics)].Namespace.String(), ShouldEqual, "/intel/use/compute/cpu/0/utilization")
+	})
+	Convey("Per cpu saturation should not be published without schedstat", t, func() {
+		mts, err := getCPUMetricTypes("proc/stat", "/some/proc/schedstat", "sys/devices/system/cpu")
+		So(err, ShouldBeNil)
+		So(len(mts), ShouldEqual, len(metricLabels)+len(cpuStatEntries)+len(runQueueMetrics)+len(loadAvgMetrics)+len(topologyMetrics)+8*(len(perCPUMetricLabels)-1+len(cpuStatEntries)))
+	})
+	Convey("Read load should return load averages and scheduling entities", t, func() {
+		load, err := readLoad("proc/loadavg")
+		So(err, ShouldBeNil)
+		So(load, ShouldResemble, &LoadAvg{Load1: 0.00, Load5: 0.01, Load15: 0.05, Running: 1, Total: 287})
+	})
+	Convey("Read procs stat should return running and blocked tasks", t, func() {
+		procs, err := readProcsStat("proc/stat")
+		So(err, ShouldBeNil)
+		So(procs, ShouldResemble, map[string]int64{"procs_running": 1, "procs_blocked": 0})
+	})
+	Convey("Read procs stat without procs lines should return error", t, func() {
+		_, err := readProcsStat("proc/loadavg")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Load average values should be normalized by number of CPUs", t, func() {
+		load := &LoadAvg{Load1: 2.0, Load5: 1.0, Load15: 0.5, Running: 3, Total: 300}
+		value, err := load.Value("load1", 4)
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, 2.0)
+		value, err = load.Value("load5_per_cpu", 4)
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, 0.25)
+		value, err = load.Value("load15_per_cpu", 4)
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, 0.125)
+		value, err = load.Value("entities_running", 4)
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, 3.0)
+		value, err = load.Value("entities_total", 4)
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, 300.0)
+		_, err = load.Value("load1_per_cpu", 0)
+		So(err, ShouldNotBeNil)
+		_, err = load.Value("load42", 4)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -2,111 +2,250 @@ package use
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
 	"github.com/pkg/errors"
 )
 
+// sectorSize is size of sector reported by /proc/diskstats, regardless of device
+const sectorSize = 512
+
+var (
+	// diskStatEntries are names of /proc/diskstats columns following device name
+	diskStatEntries = []string{
+		"reads", "readsmerged", "sectorsread", "timeread",
+		"writes", "writesmerged", "sectorswritten", "timewrite",
+		"inflight", "timeio", "weightedtimeio",
+	}
+	// diskRates are iostat like metrics computed from two measurements
+	diskRates = map[string]func(*DiskStat) float64{
+		"read_iops":        (*DiskStat).ReadIOPS,
+		"write_iops":       (*DiskStat).WriteIOPS,
+		"read_throughput":  (*DiskStat).ReadThroughput,
+		"write_throughput": (*DiskStat).WriteThroughput,
+		"read_merged":      (*DiskStat).ReadMerged,
+		"write_merged":     (*DiskStat).WriteMerged,
+		"read_await":       (*DiskStat).ReadAwait,
+		"write_await":      (*DiskStat).WriteAwait,
+		"avg_request_size": (*DiskStat).AvgRequestSize,
+	}
+	diskMetricLabels = []string{
+		"read_iops",
+		"write_iops",
+		"read_throughput",
+		"write_throughput",
+		"read_merged",
+		"write_merged",
+		"read_await",
+		"write_await",
+		"avg_request_size",
+		"in_flight",
+	}
+)
+
 // DiskStat struct for storing disk metric Data
 type DiskStat struct {
-	last         int64
-	current      int64
-	diskName     string
-	diskStatPath string
+	last     map[string]int64
+	current  map[string]int64
+	elapsed  time.Duration
+	diskName string
+	sysPath  string
 }
 
-// Utilization returns utilization of Disk Device
-func (d *DiskStat) Utilization() (float64, error) {
-	var err error
-	d.last, err = readStatForDisk(d.diskName, "timeio", d.diskStatPath)
-	if err != nil {
-		return 0.0, err
+// Utilization returns percentage of elapsed time during which Disk Device was busy
+func (d *DiskStat) Utilization() float64 {
+	return 100.0 * d.rate("timeio") / 1000.0
+}
+
+// Saturation returns average queue size of Disk Device (iostat avgqu-sz),
+// i.e. weighted time spent doing I/Os divided by elapsed time
+func (d *DiskStat) Saturation() float64 {
+	return d.rate("weightedtimeio") / 1000.0
+}
+
+// ReadIOPS returns number of completed reads per second (iostat r/s)
+func (d *DiskStat) ReadIOPS() float64 {
+	return d.rate("reads")
+}
+
+// WriteIOPS returns number of completed writes per second (iostat w/s)
+func (d *DiskStat) WriteIOPS() float64 {
+	return d.rate("writes")
+}
+
+// ReadThroughput returns number of bytes read per second (iostat rkB/s * 1024)
+func (d *DiskStat) ReadThroughput() float64 {
+	return d.rate("sectorsread") * sectorSize
+}
+
+// WriteThroughput returns number of bytes written per second (iostat wkB/s * 1024)
+func (d *DiskStat) WriteThroughput() float64 {
+	return d.rate("sectorswritten") * sectorSize
+}
+
+// ReadMerged returns number of merged read requests per second (iostat rrqm/s)
+func (d *DiskStat) ReadMerged() float64 {
+	return d.rate("readsmerged")
+}
+
+// WriteMerged returns number of merged write requests per second (iostat wrqm/s)
+func (d *DiskStat) WriteMerged() float64 {
+	return d.rate("writesmerged")
+}
+
+// ReadAwait returns average time in milliseconds of read requests (iostat r_await)
+func (d *DiskStat) ReadAwait() float64 {
+	return ratio(d.delta("timeread"), d.delta("reads"))
+}
+
+// WriteAwait returns average time in milliseconds of write requests (iostat w_await)
+func (d *DiskStat) WriteAwait() float64 {
+	return ratio(d.delta("timewrite"), d.delta("writes"))
+}
+
+// AvgRequestSize returns average size in bytes of requests (iostat avgrq-sz * 512)
+func (d *DiskStat) AvgRequestSize() float64 {
+	return ratio((d.delta("sectorsread")+d.delta("sectorswritten"))*sectorSize, d.delta("reads")+d.delta("writes"))
+}
+
+// InFlight returns number of I/Os currently in progress
+func (d *DiskStat) InFlight() float64 {
+	return float64(d.current["inflight"])
+}
+
+func (d *DiskStat) delta(key string) float64 {
+	if d.current[key] < d.last[key] {
+		// counter was reset
+		return 0.0
 	}
-	time.Sleep(waitTime)
-	d.current, err = readStatForDisk(d.diskName, "timeio", d.diskStatPath)
-	if err != nil {
-		return 0.0, err
+	return float64(d.current[key] - d.last[key])
+}
+
+// rate returns per second change of counter between last and current measurement
+func (d *DiskStat) rate(key string) float64 {
+	if d.elapsed <= 0 {
+		return 0.0
 	}
-	return float64(d.current-d.last) / 10.0, nil
+	return d.delta(key) / d.elapsed.Seconds()
 }
 
-// Saturation returns saturation of Disk Device
-func (d *DiskStat) Saturation() (float64, error) {
-	var err error
-	d.last, err = readStatForDisk(d.diskName, "weightedtimeio", d.diskStatPath)
-	if err != nil {
-		return 0.0, err
+// Errors returns number of IO errors of Disk Device
+func (d *DiskStat) Errors() (float64, error) {
+	ioErrPath := filepath.Join(d.sysPath, "block", d.diskName, "device", "ioerr_cnt")
+	if _, err := os.Stat(ioErrPath); os.IsNotExist(err) {
+		// only SCSI devices keep track of IO errors
+		return 0.0, nil
 	}
-	d.current, err = readStatForDisk(d.diskName, "weightedtimeio", d.diskStatPath)
+	count, err := readHexInt(ioErrPath)
 	if err != nil {
 		return 0.0, err
 	}
-	// 10ms * 10 ticks
-	return float64(d.current-d.last) / 100.0, nil
+	return float64(count), nil
 }
 
-func getDiskMetricTypes() ([]plugin.Metric, error) {
+func getDiskMetricTypes(sysPath string, diskStatPath string, filter *deviceFilter) ([]plugin.Metric, error) {
 	var mts []plugin.Metric
 
-	for _, diskName := range listDisks() {
+	disks, err := listDisks(sysPath, diskStatPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, diskName := range filter.filter(disks) {
 		for _, name := range metricLabels {
 			mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "storage", diskName, name)})
 		}
+		for _, name := range diskMetricLabels {
+			mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "storage", diskName, name)})
+		}
 
 	}
 	return mts, nil
 }
 
-func listDisks() []string {
-	cmd := "lsblk"
-	args := []string{"-d", "--noheadings", "--list", "-o", "NAME"}
-	output, err := run(cmd, args)
+// listDisks returns whole block devices listed in sys_path/block which
+// have statistics in proc_path/diskstats. When sys_path is not available
+// every device from diskstats is returned.
+func listDisks(sysPath string, diskStatPath string) ([]string, error) {
+	lines, err := readLines(diskStatPath)
 	if err != nil {
-		return []string{}
+		return nil, err
+	}
+	stats := []string{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		stats = append(stats, fields[2])
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(sysPath, "block"))
+	if err != nil {
+		log.Warnf("Unable to list block devices in %s, using all devices from %s: %s", sysPath, diskStatPath, err.Error())
+		return stats, nil
+	}
+	blockDevices := map[string]bool{}
+	for _, entry := range entries {
+		blockDevices[entry.Name()] = true
 	}
 	disks := []string{}
-	for _, disk := range strings.Split(string(output), "\n") {
-		parts := strings.Split(disk, " ")
-		if len(parts) == 1 {
-			// We assume a multipart string is not a supported disk
-			// e.g: "lsblk: dm-0: failed to get device path"
+	for _, disk := range stats {
+		if blockDevices[disk] {
 			disks = append(disks, disk)
 		}
 	}
 
-	return disks
+	return disks, nil
 }
 
 func readStatForDisk(diskName string, statType string, diskStatPath string) (int64, error) {
-	lines, err := readLines(diskStatPath)
+	stat, err := readStatsForDisk(diskName, diskStatPath)
 	if err != nil {
 		return 0, err
 	}
+	val, ok := stat[statType]
+	if !ok {
+		return 0, errors.Errorf("Unknown disk stat %s", statType)
+	}
+	return val, nil
+}
+
+func readStatsForDisk(diskName string, diskStatPath string) (map[string]int64, error) {
+	lines, err := readLines(diskStatPath)
+	if err != nil {
+		return nil, err
+	}
 	for _, line := range lines {
 		fields := strings.Fields(line)
-		if diskName == fields[2] {
-			switch statType {
-			case "timeio":
-				return strconv.ParseInt((fields[12]), 10, 64)
-			case "weightedtimeio":
-				return strconv.ParseInt((fields[13]), 10, 64)
+		if len(fields) < 3+len(diskStatEntries) || diskName != fields[2] {
+			continue
+		}
+		stat := map[string]int64{}
+		for i, entry := range diskStatEntries {
+			stat[entry], err = strconv.ParseInt(fields[i+3], 10, 64)
+			if err != nil {
+				return nil, errors.Errorf("Unable to parse %s of disk %s: %s", entry, diskName, err.Error())
 			}
 		}
+		return stat, nil
 	}
 
-	return 0, fmt.Errorf("Can't find a disk %s.\n", diskName)
+	return nil, fmt.Errorf("Can't find a disk %s.\n", diskName)
 }
 
 func (u *Use) diskStat(ns plugin.Namespace) (*plugin.Metric, error) {
 	diskName := ns.Strings()[3]
 	switch {
 	case regexp.MustCompile(`^/intel/use/storage/.*/utilization$`).MatchString(ns.String()):
-		diskStat := DiskStat{diskName: diskName, diskStatPath: u.DiskStatPath}
-		metric, err := diskStat.Utilization()
+		metric, err := u.diskRate(ns, diskName, (*DiskStat).Utilization)
 		if err != nil {
 			return nil, errors.Errorf("Unable to get disk utilization: %s", err.Error())
 		}
@@ -115,17 +254,130 @@ func (u *Use) diskStat(ns plugin.Namespace) (*plugin.Metric, error) {
 			Data:      metric,
 		}, nil
 	case regexp.MustCompile(`^/intel/use/storage/.*/saturation$`).MatchString(ns.String()):
-		diskStat := DiskStat{diskName: diskName, diskStatPath: u.DiskStatPath}
-		metric, err := diskStat.Saturation()
+		metric, err := u.diskRate(ns, diskName, (*DiskStat).Saturation)
 		if err != nil {
 			return nil, errors.Errorf("Unable to get disk saturation: " + err.Error())
 		}
 
 		return &plugin.Metric{
 			Namespace: ns,
-			Data:      float64(metric),
+			Data:      metric,
+		}, nil
+	case regexp.MustCompile(`^/intel/use/storage/.*/errors$`).MatchString(ns.String()):
+		diskStat := DiskStat{diskName: diskName, sysPath: u.SysPath}
+		metric, err := diskStat.Errors()
+		if err != nil {
+			return nil, errors.Errorf("Unable to get disk errors: %s", err.Error())
+		}
+
+		return &plugin.Metric{
+			Namespace: ns,
+			Data:      metric,
+		}, nil
+	case regexp.MustCompile(`^/intel/use/storage/.*/in_flight$`).MatchString(ns.String()):
+		current, err := readStatsForDisk(diskName, u.DiskStatPath)
+		if err != nil {
+			return nil, errors.Errorf("Unable to get disk in flight: %s", err.Error())
+		}
+		diskStat := DiskStat{current: current}
+
+		return &plugin.Metric{
+			Namespace: ns,
+			Data:      diskStat.InFlight(),
+		}, nil
+	}
+
+	if rate, ok := diskRates[ns.Strings()[len(ns)-1]]; ok {
+		metric, err := u.diskRate(ns, diskName, rate)
+		if err != nil {
+			return nil, errors.Errorf("Unable to get disk %s: %s", ns.Strings()[len(ns)-1], err.Error())
+		}
+		return &plugin.Metric{
+			Namespace: ns,
+			Data:      metric,
 		}, nil
 	}
 
 	return nil, errors.Errorf("Unknown disk stat namespace %v", ns)
 }
+
+// diskRate computes metric of disk between previous and current collection of namespace
+func (u *Use) diskRate(ns plugin.Namespace, diskName string, rate func(*DiskStat) float64) (float64, error) {
+	current, err := readStatsForDisk(diskName, u.DiskStatPath)
+	if err != nil {
+		return 0.0, err
+	}
+	last, elapsed, ok := u.swapSnapshot(ns, current)
+	if !ok {
+		return 0.0, nil
+	}
+	diskStat := DiskStat{last: last.counters, current: current, elapsed: elapsed}
+	return rate(&diskStat), nil
+}
+
+// diskTypes maps device name prefixes to storage device types
+var diskTypes = []struct {
+	prefix   string
+	diskType string
+}{
+	{"nvme", "nvme"},
+	{"sd", "sd"},
+	{"dm-", "dm"},
+	{"md", "md"},
+	{"vd", "vd"},
+	{"xvd", "xvd"},
+	{"hd", "hd"},
+	{"sr", "sr"},
+	{"mmcblk", "mmc"},
+	{"loop", "loop"},
+	{"ram", "ram"},
+}
+
+// diskType returns type of storage device based on kernel device name
+func diskType(diskName string) string {
+	for _, t := range diskTypes {
+		if strings.HasPrefix(diskName, t.prefix) {
+			return t.diskType
+		}
+	}
+	return "other"
+}
+
+// readDiskTags returns metadata of storage device from sysfs, properties
+// which are not available for the device are skipped
+func readDiskTags(diskName string, sysPath string) map[string]string {
+	blockPath := filepath.Join(sysPath, "block", diskName)
+	tags := map[string]string{"device_type": diskType(diskName)}
+
+	if rotational, err := readString(filepath.Join(blockPath, "queue", "rotational")); err == nil {
+		tags["rotational"] = strconv.FormatBool(rotational == "1")
+	}
+	if scheduler, err := readString(filepath.Join(blockPath, "queue", "scheduler")); err == nil {
+		tags["scheduler"] = activeScheduler(scheduler)
+	}
+	if sectors, err := readString(filepath.Join(blockPath, "size")); err == nil {
+		if size, err := strconv.ParseInt(sectors, 10, 64); err == nil {
+			tags["size"] = strconv.FormatInt(size*sectorSize, 10)
+		}
+	}
+	for tag, file := range map[string]string{"model": "model", "vendor": "vendor"} {
+		if value, err := readString(filepath.Join(blockPath, "device", file)); err == nil && value != "" {
+			tags[tag] = value
+		}
+	}
+	if dmName, err := readString(filepath.Join(blockPath, "dm", "name")); err == nil && dmName != "" {
+		tags["dm_name"] = dmName
+	}
+	return tags
+}
+
+// activeScheduler returns I/O scheduler selected in brackets,
+// e.g. cfq for "noop deadline [cfq]"
+func activeScheduler(schedulers string) string {
+	for _, s := range strings.Fields(schedulers) {
+		if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+			return strings.Trim(s, "[]")
+		}
+	}
+	return schedulers
+}
@@ -22,17 +22,15 @@ limitations under the License.
 package use
 
 import (
-	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
 
 func TestDiskUsePlugin(t *testing.T) {
-	procPath = "proc"
-	diskStatPath = filepath.Join(procPath, "diskstats")
 	Convey("Read disk data should return proper timeio", t, func() {
-		file, err := readStatForDisk("sda", "timeio")
+		file, err := readStatForDisk("sda", "timeio", "proc/diskstats")
 		So(err, ShouldBeNil)
 		var expectedValue int64
 		expectedValue = 208788
@@ -41,7 +39,7 @@ func TestDiskUsePlugin(t *testing.T) {
 
 	})
 	Convey("Read disk data should return proper weightedtimeio", t, func() {
-		file, err := readStatForDisk("sda", "weightedtimeio")
+		file, err := readStatForDisk("sda", "weightedtimeio", "proc/diskstats")
 		So(err, ShouldBeNil)
 		var expectedValue int64
 		expectedValue = 10474225
@@ -49,29 +47,153 @@ func TestDiskUsePlugin(t *testing.T) {
 		So(err, ShouldBeNil)
 
 	})
-	procPath = "/some/proc"
-	diskStatPath = filepath.Join(procPath, "diskstats")
 	Convey("Read memory data when file not available should return error", t, func() {
-		file, err := readStatForDisk("sda", "timeio")
+		file, err := readStatForDisk("sda", "timeio", "/some/proc/diskstats")
 		var expectedValue int64
 		So(file, ShouldResemble, expectedValue)
-		So(err.Error(), ShouldResemble, "open /some/proc/diskstats: no such file or directory")
+		So(err.Error(), ShouldResemble, "Unable to open file /some/proc/diskstats: open /some/proc/diskstats: no such file or directory")
 
 	})
 
-	procPath = "proc"
-	diskStatPath = filepath.Join(procPath, "diskstats")
-	Convey("get Utilization should return proper value", t, func() {
-		d := DiskStat{diskName: "sda"}
-		utilization, err := d.Utilization()
-		So(utilization, ShouldResemble, 0.0)
+	Convey("List disks should return whole block devices with statistics", t, func() {
+		disks, err := listDisks("sys", "proc/diskstats")
 		So(err, ShouldBeNil)
+		So(disks, ShouldResemble, []string{"sda", "sr0", "loop0", "ram0", "dm-0", "dm-1", "dm-2"})
 	})
 
-	Convey("get Saturation should return proper value", t, func() {
-		d := DiskStat{diskName: "sda"}
-		saturation, err := d.Saturation()
-		So(saturation, ShouldResemble, 0.0)
+	Convey("List disks without sysfs should return all devices with statistics", t, func() {
+		disks, err := listDisks("/some/sys", "proc/diskstats")
 		So(err, ShouldBeNil)
+		So(disks, ShouldResemble, []string{"sda", "sda1", "sda2", "sda3", "sr0", "loop0", "ram0", "dm-0", "dm-1", "dm-2"})
+	})
+
+	Convey("List disks without diskstats should return error", t, func() {
+		_, err := listDisks("sys", "/some/proc/diskstats")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Read disk data should return all diskstats columns", t, func() {
+		stat, err := readStatsForDisk("sda", "proc/diskstats")
+		So(err, ShouldBeNil)
+		So(stat, ShouldResemble, map[string]int64{
+			"reads": 30988, "readsmerged": 10189, "sectorsread": 879095, "timeread": 465356,
+			"writes": 20185, "writesmerged": 24590, "sectorswritten": 491652, "timewrite": 10008870,
+			"inflight": 0, "timeio": 208788, "weightedtimeio": 10474225,
+		})
+	})
+
+	Convey("Read disk data of unknown disk should return error", t, func() {
+		_, err := readStatsForDisk("sdz", "proc/diskstats")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given disk data taken one second apart", t, func() {
+		last, err := readStatsForDisk("sda", "proc/diskstats")
+		So(err, ShouldBeNil)
+		current, err := readStatsForDisk("sda", "proc/diskstats_1s")
+		So(err, ShouldBeNil)
+		d := DiskStat{last: last, current: current, elapsed: time.Second}
+
+		Convey("get Utilization should return proper value", func() {
+			So(d.Utilization(), ShouldResemble, 65.0)
+		})
+		Convey("get Saturation should return average queue size", func() {
+			So(d.Saturation(), ShouldResemble, 2.4)
+		})
+		Convey("get iops should return proper values", func() {
+			So(d.ReadIOPS(), ShouldResemble, 120.0)
+			So(d.WriteIOPS(), ShouldResemble, 80.0)
+		})
+		Convey("get throughput should return bytes per second", func() {
+			So(d.ReadThroughput(), ShouldResemble, 491520.0)
+			So(d.WriteThroughput(), ShouldResemble, 655360.0)
+		})
+		Convey("get merged should return proper values", func() {
+			So(d.ReadMerged(), ShouldResemble, 10.0)
+			So(d.WriteMerged(), ShouldResemble, 40.0)
+		})
+		Convey("get await should return proper values", func() {
+			So(d.ReadAwait(), ShouldResemble, 3.0)
+			So(d.WriteAwait(), ShouldResemble, 25.5)
+		})
+		Convey("get AvgRequestSize should return proper value", func() {
+			So(d.AvgRequestSize(), ShouldResemble, 5734.4)
+		})
+		Convey("get InFlight should return current value", func() {
+			So(d.InFlight(), ShouldResemble, 2.0)
+		})
+	})
+
+	Convey("get Saturation over longer interval should be normalized", t, func() {
+		d := DiskStat{
+			last:    map[string]int64{"weightedtimeio": 10474225},
+			current: map[string]int64{"weightedtimeio": 10476625},
+			elapsed: 4 * time.Second,
+		}
+		So(d.Saturation(), ShouldResemble, 0.6)
+	})
+
+	Convey("get Saturation of idle disk should return zero", t, func() {
+		last, err := readStatsForDisk("sr0", "proc/diskstats")
+		So(err, ShouldBeNil)
+		current, err := readStatsForDisk("sr0", "proc/diskstats_1s")
+		So(err, ShouldBeNil)
+		d := DiskStat{last: last, current: current, elapsed: time.Second}
+		So(d.Saturation(), ShouldResemble, 0.0)
+		So(d.ReadAwait(), ShouldResemble, 0.0)
+		So(d.AvgRequestSize(), ShouldResemble, 0.0)
+	})
+
+	Convey("get Saturation without elapsed time should return zero", t, func() {
+		d := DiskStat{
+			last:    map[string]int64{"weightedtimeio": 10474225},
+			current: map[string]int64{"weightedtimeio": 10476625},
+		}
+		So(d.Saturation(), ShouldResemble, 0.0)
+	})
+
+	Convey("get Errors should return proper value", t, func() {
+		d := DiskStat{diskName: "sda", sysPath: "sys"}
+		errs, err := d.Errors()
+		So(errs, ShouldResemble, 2.0)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("get Errors of device without error counter should return zero", t, func() {
+		d := DiskStat{diskName: "dm-0", sysPath: "sys"}
+		errs, err := d.Errors()
+		So(errs, ShouldResemble, 0.0)
+		So(err, ShouldBeNil)
+	})
+	Convey("Storage device type should be derived from device name", t, func() {
+		So(diskType("nvme0n1"), ShouldEqual, "nvme")
+		So(diskType("sda"), ShouldEqual, "sd")
+		So(diskType("dm-0"), ShouldEqual, "dm")
+		So(diskType("md127"), ShouldEqual, "md")
+		So(diskType("mmcblk0"), ShouldEqual, "mmc")
+		So(diskType("zram0"), ShouldEqual, "other")
+	})
+	Convey("Active scheduler should be selected", t, func() {
+		So(activeScheduler("noop deadline [cfq]"), ShouldEqual, "cfq")
+		So(activeScheduler("[mq-deadline] kyber none"), ShouldEqual, "mq-deadline")
+		So(activeScheduler("none"), ShouldEqual, "none")
+	})
+	Convey("Storage device tags should be read from sysfs", t, func() {
+		So(readDiskTags("sda", "sys"), ShouldResemble, map[string]string{
+			"device_type": "sd",
+			"rotational":  "true",
+			"scheduler":   "cfq",
+			"size":        "1000204886016",
+			"model":       "ST1000DM003-1SB1",
+			"vendor":      "ATA",
+		})
+		So(readDiskTags("dm-1", "sys"), ShouldResemble, map[string]string{
+			"device_type": "dm",
+			"rotational":  "false",
+			"scheduler":   "none",
+			"size":        "53687091200",
+			"dm_name":     "vg0-root",
+		})
+		So(readDiskTags("sdz", "/some/sys"), ShouldResemble, map[string]string{"device_type": "sd"})
 	})
 }
@@ -0,0 +1,74 @@
+package use
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+	"github.com/pkg/errors"
+)
+
+// deviceFilter selects devices published in metric catalog
+type deviceFilter struct {
+	include      *regexp.Regexp
+	exclude      *regexp.Regexp
+	physicalOnly bool
+	// classPath is sysfs directory with device entries, e.g. /sys/block
+	classPath string
+}
+
+// newDeviceFilter creates filter from <prefix>_include and <prefix>_exclude
+// regular expressions and physical_devices_only switch of plugin config
+func newDeviceFilter(cfg plugin.Config, prefix string, classPath string) (*deviceFilter, error) {
+	f := &deviceFilter{classPath: classPath}
+
+	include, err := cfg.GetString(prefix + "_include")
+	if err == nil && include != "" {
+		f.include, err = regexp.Compile(include)
+		if err != nil {
+			return nil, errors.Errorf("Invalid %s_include expression %s: %s", prefix, include, err.Error())
+		}
+	}
+	exclude, err := cfg.GetString(prefix + "_exclude")
+	if err == nil && exclude != "" {
+		f.exclude, err = regexp.Compile(exclude)
+		if err != nil {
+			return nil, errors.Errorf("Invalid %s_exclude expression %s: %s", prefix, exclude, err.Error())
+		}
+	}
+	physicalOnly, err := cfg.GetBool("physical_devices_only")
+	if err == nil {
+		f.physicalOnly = physicalOnly
+	}
+
+	return f, nil
+}
+
+// filter returns devices which are allowed by filter
+func (f *deviceFilter) filter(devices []string) []string {
+	if f == nil {
+		return devices
+	}
+	allowed := []string{}
+	for _, device := range devices {
+		if f.include != nil && !f.include.MatchString(device) {
+			continue
+		}
+		if f.exclude != nil && f.exclude.MatchString(device) {
+			continue
+		}
+		if f.physicalOnly && !f.isPhysical(device) {
+			continue
+		}
+		allowed = append(allowed, device)
+	}
+	return allowed
+}
+
+// isPhysical checks if device is backed by hardware, virtual devices
+// like loop, dm-* or veth do not have device link in sysfs
+func (f *deviceFilter) isPhysical(device string) bool {
+	_, err := os.Stat(filepath.Join(f.classPath, device, "device"))
+	return err == nil
+}
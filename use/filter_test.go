@@ -0,0 +1,73 @@
+//
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package use
+
+import (
+	"testing"
+
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeviceFilter(t *testing.T) {
+	disks := []string{"sda", "sr0", "loop0", "ram0", "dm-0", "dm-1", "dm-2"}
+
+	Convey("Filter without rules should allow all devices", t, func() {
+		f, err := newDeviceFilter(plugin.Config{}, "storage", "sys/block")
+		So(err, ShouldBeNil)
+		So(f.filter(disks), ShouldResemble, disks)
+	})
+	Convey("Nil filter should allow all devices", t, func() {
+		var f *deviceFilter
+		So(f.filter(disks), ShouldResemble, disks)
+	})
+	Convey("Filter should apply include expression", t, func() {
+		f, err := newDeviceFilter(plugin.Config{"storage_include": "^(sd|dm-)"}, "storage", "sys/block")
+		So(err, ShouldBeNil)
+		So(f.filter(disks), ShouldResemble, []string{"sda", "dm-0", "dm-1", "dm-2"})
+	})
+	Convey("Filter should apply exclude expression", t, func() {
+		f, err := newDeviceFilter(plugin.Config{"storage_exclude": "^(loop|ram|sr)"}, "storage", "sys/block")
+		So(err, ShouldBeNil)
+		So(f.filter(disks), ShouldResemble, []string{"sda", "dm-0", "dm-1", "dm-2"})
+	})
+	Convey("Filter should apply exclude after include", t, func() {
+		f, err := newDeviceFilter(plugin.Config{"storage_include": "^dm-", "storage_exclude": "^dm-0$"}, "storage", "sys/block")
+		So(err, ShouldBeNil)
+		So(f.filter(disks), ShouldResemble, []string{"dm-1", "dm-2"})
+	})
+	Convey("Filter should keep only physical storage devices", t, func() {
+		f, err := newDeviceFilter(plugin.Config{"physical_devices_only": true}, "storage", "sys/block")
+		So(err, ShouldBeNil)
+		So(f.filter(disks), ShouldResemble, []string{"sda", "sr0"})
+	})
+	Convey("Filter should keep only physical network devices", t, func() {
+		f, err := newDeviceFilter(plugin.Config{"physical_devices_only": true}, "network", "sys/class/net")
+		So(err, ShouldBeNil)
+		So(f.filter([]string{"eth0", "docker0"}), ShouldResemble, []string{"eth0"})
+	})
+	Convey("Filter with invalid expression should return error", t, func() {
+		_, err := newDeviceFilter(plugin.Config{"network_exclude": "("}, "network", "sys/class/net")
+		So(err, ShouldNotBeNil)
+	})
+}
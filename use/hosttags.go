@@ -0,0 +1,154 @@
+package use
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// dmiTags maps host tags to files in /sys/class/dmi/id
+var dmiTags = map[string]string{
+	"system_vendor":  "sys_vendor",
+	"system_product": "product_name",
+}
+
+// hypervisors maps DMI vendor or product name prefixes to virtualization systems
+var hypervisors = map[string]string{
+	"QEMU":            "kvm",
+	"KVM":             "kvm",
+	"Bochs":           "kvm",
+	"Amazon EC2":      "kvm",
+	"Google":          "kvm",
+	"VMware":          "vmware",
+	"innotek GmbH":    "vbox",
+	"VirtualBox":      "vbox",
+	"Xen":             "xen",
+	"Virtual Machine": "hyperv",
+}
+
+// hostTags returns tags identifying measured host including tags of enabled
+// providers, tags are resolved once and refreshed when older than configured
+// refresh interval
+func (u *Use) hostTags() map[string]string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	now := time.Now()
+	if u.tags == nil || (u.TagsRefresh > 0 && now.Sub(u.tagsUpdated) >= u.TagsRefresh) {
+		u.tags = readHostTags(u.ProcPath, u.SysPath, u.RootPath)
+		if u.tagConfig != nil {
+			for _, name := range u.tagConfig.providers {
+				for k, v := range tagProviders[name](u.ProcPath, u.SysPath) {
+					u.tags[k] = v
+				}
+			}
+		}
+		u.tagsUpdated = now
+	}
+	tags := make(map[string]string, len(u.tags))
+	for k, v := range u.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// cachedTags contains tags of a device or workload and time when they were read
+type cachedTags struct {
+	tags    map[string]string
+	updated time.Time
+}
+
+// cachedTags returns tags stored under key, tags are read again when they
+// are older than host tags refresh interval
+func (u *Use) cachedTags(key string, read func() map[string]string) map[string]string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.deviceTags == nil {
+		u.deviceTags = map[string]cachedTags{}
+	}
+	now := time.Now()
+	cached, ok := u.deviceTags[key]
+	if !ok || (u.TagsRefresh > 0 && now.Sub(cached.updated) >= u.TagsRefresh) {
+		cached = cachedTags{tags: read(), updated: now}
+		u.deviceTags[key] = cached
+	}
+	tags := make(map[string]string, len(cached.tags))
+	for k, v := range cached.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// readHostTags reads hostname from procfs, distribution from os-release
+// under root path and hardware vendor from DMI, unavailable tags are skipped
+func readHostTags(procPath string, sysPath string, rootPath string) map[string]string {
+	tags := map[string]string{}
+
+	if hostname, err := readString(filepath.Join(procPath, "sys", "kernel", "hostname")); err == nil {
+		tags["hostname"] = hostname
+	} else {
+		log.Warnf("Unable to get hostname: %s", err.Error())
+	}
+	if ostype, err := readString(filepath.Join(procPath, "sys", "kernel", "ostype")); err == nil {
+		tags["os"] = strings.ToLower(ostype)
+	}
+
+	if release, err := readOSRelease(rootPath); err == nil {
+		if id, ok := release["ID"]; ok {
+			tags["platform"] = id
+			tags["platform_family"] = id
+		}
+		if like := strings.Fields(release["ID_LIKE"]); len(like) > 0 {
+			tags["platform_family"] = like[0]
+		}
+		if version, ok := release["VERSION_ID"]; ok {
+			tags["platform_version"] = version
+		}
+	} else {
+		log.Warnf("Unable to get os release: %s", err.Error())
+	}
+
+	dmiPath := filepath.Join(sysPath, "class", "dmi", "id")
+	for tag, file := range dmiTags {
+		if value, err := readString(filepath.Join(dmiPath, file)); err == nil && value != "" {
+			tags[tag] = value
+		}
+	}
+	for prefix, system := range hypervisors {
+		if strings.HasPrefix(tags["system_vendor"], prefix) || strings.HasPrefix(tags["system_product"], prefix) {
+			tags["virtualization_system"] = system
+			tags["virtualization_role"] = "guest"
+			break
+		}
+	}
+
+	return tags
+}
+
+// readOSRelease parses os-release file of root path, /etc/os-release takes
+// precedence over /usr/lib/os-release
+func readOSRelease(rootPath string) (map[string]string, error) {
+	lines, err := readLines(filepath.Join(rootPath, "etc", "os-release"))
+	if err != nil {
+		lines, err = readLines(filepath.Join(rootPath, "usr", "lib", "os-release"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	release := map[string]string{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		release[parts[0]] = strings.Trim(parts[1], `"'`)
+	}
+	return release, nil
+}
@@ -0,0 +1,77 @@
+//
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package use
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHostTags(t *testing.T) {
+	Convey("Host tags should be read from proc, sys and root paths", t, func() {
+		tags := readHostTags("proc", "sys", "rootfs")
+		So(tags, ShouldResemble, map[string]string{
+			"hostname":              "node-01",
+			"os":                    "linux",
+			"platform":              "ubuntu",
+			"platform_family":       "debian",
+			"platform_version":      "16.04",
+			"system_vendor":         "QEMU",
+			"system_product":        "Standard PC (i440FX + PIIX, 1996)",
+			"virtualization_system": "kvm",
+			"virtualization_role":   "guest",
+		})
+	})
+	Convey("Unavailable host tags should be skipped", t, func() {
+		tags := readHostTags("/some/proc", "/some/sys", "/some/root")
+		So(tags, ShouldBeEmpty)
+	})
+	Convey("Host tags should be cached until refresh interval passes", t, func() {
+		u := &Use{ProcPath: "proc", SysPath: "sys", RootPath: "rootfs", TagsRefresh: time.Hour}
+		So(u.hostTags()["hostname"], ShouldEqual, "node-01")
+		u.ProcPath = "/some/proc"
+		So(u.hostTags()["hostname"], ShouldEqual, "node-01")
+		u.tagsUpdated = time.Now().Add(-2 * time.Hour)
+		So(u.hostTags(), ShouldNotContainKey, "hostname")
+	})
+	Convey("Returned host tags should not share cached map", t, func() {
+		u := &Use{ProcPath: "proc", SysPath: "sys", RootPath: "rootfs"}
+		u.hostTags()["hostname"] = "changed"
+		So(u.hostTags()["hostname"], ShouldEqual, "node-01")
+	})
+	Convey("Device tags should be cached until refresh interval passes", t, func() {
+		u := &Use{SysPath: "sys", TagsRefresh: time.Hour}
+		read := func() map[string]string { return readDiskTags("sda", u.SysPath) }
+		So(u.cachedTags("storage/sda", read)["model"], ShouldEqual, "ST1000DM003-1SB1")
+		u.SysPath = "/some/sys"
+		tags := u.cachedTags("storage/sda", read)
+		So(tags["model"], ShouldEqual, "ST1000DM003-1SB1")
+		tags["model"] = "changed"
+		So(u.cachedTags("storage/sda", read)["model"], ShouldEqual, "ST1000DM003-1SB1")
+		cached := u.deviceTags["storage/sda"]
+		cached.updated = time.Now().Add(-2 * time.Hour)
+		u.deviceTags["storage/sda"] = cached
+		So(u.cachedTags("storage/sda", read), ShouldNotContainKey, "model")
+	})
+}
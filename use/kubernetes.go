@@ -0,0 +1,190 @@
+package use
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// kubePodRe matches pod cgroup of cgroupfs (pod<uid>) and systemd
+	// (kubepods-<qos>-pod<uid>.slice) drivers, systemd replaces - in uid with _
+	kubePodRe = regexp.MustCompile(`^(?:kubepods-(?:burstable-|besteffort-)?)?pod([0-9a-fA-F_-]+)(?:\.slice)?$`)
+	// kubeQoSRe matches QoS class cgroup of cgroupfs and systemd drivers
+	kubeQoSRe = regexp.MustCompile(`^(?:kubepods-)?(burstable|besteffort)(?:\.slice)?$`)
+	// kubeContainerRe matches container cgroup, systemd driver adds runtime prefix
+	kubeContainerRe = regexp.MustCompile(`^(?:(?:cri-containerd|containerd|docker|crio)-)?([0-9a-f]{64})(?:\.scope)?$`)
+)
+
+// containerLabels maps container runtime labels to tags
+var containerLabels = map[string]string{
+	"io.kubernetes.pod.name":       "pod_name",
+	"io.kubernetes.pod.namespace":  "pod_namespace",
+	"io.kubernetes.container.name": "container_name",
+}
+
+// parseKubeCgroup returns pod uid, QoS class and container id of cgroup
+// created by kubelet, nil is returned for cgroups outside of kubepods
+func parseKubeCgroup(cgroup string) map[string]string {
+	segments := strings.Split(cgroup, "/")
+	start := -1
+	for i, segment := range segments {
+		if segment == "kubepods" || segment == "kubepods.slice" {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+
+	tags := map[string]string{}
+	for _, segment := range segments[start+1:] {
+		if _, ok := tags["pod_uid"]; !ok {
+			if m := kubeQoSRe.FindStringSubmatch(segment); m != nil {
+				tags["qos_class"] = m[1]
+				continue
+			}
+			if m := kubePodRe.FindStringSubmatch(segment); m != nil {
+				tags["pod_uid"] = strings.Replace(m[1], "_", "-", -1)
+				continue
+			}
+			return nil
+		}
+		if m := kubeContainerRe.FindStringSubmatch(segment); m != nil {
+			tags["container_id"] = m[1]
+		}
+		break
+	}
+	if _, ok := tags["pod_uid"]; !ok {
+		return nil
+	}
+	if _, ok := tags["qos_class"]; !ok {
+		tags["qos_class"] = "guaranteed"
+	}
+	return tags
+}
+
+// kubeHostsHeader starts hosts file generated by kubelet for pods with own
+// network namespace, hosts file of host network pods is a copy of node's one
+const kubeHostsHeader = "# Kubernetes-managed hosts file."
+
+// readKubeletPod returns name and namespace of pod from kubelet pods
+// directory, pod name is read from hostname in kubelet managed hosts file
+func readKubeletPod(podsPath string, uid string) map[string]string {
+	tags := map[string]string{}
+	podPath := filepath.Join(podsPath, uid)
+
+	lines, err := readLines(filepath.Join(podPath, "etc-hosts"))
+	if err != nil {
+		log.Debugf("Unable to get name of pod %s: %s", uid, err.Error())
+	}
+	if name := parseKubeHosts(lines); name != "" {
+		tags["pod_name"] = name
+	}
+
+	// namespace is part of service account token volume mounted to pod
+	for _, volume := range []string{"kubernetes.io~projected", "kubernetes.io~secret"} {
+		files, _ := filepath.Glob(filepath.Join(podPath, "volumes", volume, "*", "namespace"))
+		for _, file := range files {
+			if namespace, err := readString(file); err == nil && namespace != "" {
+				tags["pod_namespace"] = namespace
+				return tags
+			}
+		}
+	}
+	return tags
+}
+
+// parseKubeHosts returns hostname of pod from kubelet managed hosts file,
+// pod entry is the last one before host aliases and its last field is short
+// hostname, the pod name unless hostname is set in pod spec
+func parseKubeHosts(lines []string) string {
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != kubeHostsHeader {
+		return ""
+	}
+	hostname := ""
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.HasPrefix(fields[0], "#") {
+			// host aliases follow pod entry
+			break
+		}
+		if len(fields) >= 2 {
+			hostname = fields[len(fields)-1]
+		}
+	}
+	return hostname
+}
+
+// readContainerState returns pod and container names from kubernetes labels
+// or annotations in container runtime state file, {id} in pattern is
+// replaced with container id, e.g. /var/lib/docker/containers/{id}/config.v2.json
+func readContainerState(pattern string, id string) map[string]string {
+	tags := map[string]string{}
+	data, err := ioutil.ReadFile(strings.Replace(pattern, "{id}", id, -1))
+	if err != nil {
+		log.Debugf("Unable to read state of container %s: %s", id, err.Error())
+		return tags
+	}
+	var state interface{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Warnf("Unable to parse state of container %s: %s", id, err.Error())
+		return tags
+	}
+	findContainerLabels(state, tags)
+	return tags
+}
+
+// findContainerLabels searches decoded JSON for kubernetes labels, runtimes
+// keep them in different places, e.g. Config.Labels or annotations
+func findContainerLabels(node interface{}, tags map[string]string) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if tag, ok := containerLabels[key]; ok {
+				if s, ok := value.(string); ok && s != "" {
+					tags[tag] = s
+				}
+				continue
+			}
+			findContainerLabels(value, tags)
+		}
+	case []interface{}:
+		for _, value := range v {
+			findContainerLabels(value, tags)
+		}
+	}
+}
+
+// kubeTags returns kubernetes identity tags of cgroup, names are resolved
+// only when kubelet pods or container state paths are configured and are
+// cached by pod uid and container id
+func (u *Use) kubeTags(cgroup string) map[string]string {
+	tags := parseKubeCgroup(cgroup)
+	if tags == nil {
+		return nil
+	}
+	if u.KubeletPodsPath != "" {
+		uid := tags["pod_uid"]
+		pod := u.cachedTags("pod/"+uid, func() map[string]string { return readKubeletPod(u.KubeletPodsPath, uid) })
+		for k, v := range pod {
+			tags[k] = v
+		}
+	}
+	if u.ContainerStatePath != "" && tags["container_id"] != "" {
+		id := tags["container_id"]
+		container := u.cachedTags("container/"+id, func() map[string]string { return readContainerState(u.ContainerStatePath, id) })
+		for k, v := range container {
+			tags[k] = v
+		}
+	}
+	return tags
+}
@@ -0,0 +1,117 @@
+//
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package use
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	testPodUID      = "1f0b8e3a-4c2d-4e5f-9a7b-2c3d4e5f6a7b"
+	testContainerID = "3c5e7f9b1d2a4c6e8f0a1b3c5d7e9f1a2b4c6d8e0f1a3b5c7d9e1f2a4b6c8d0e"
+)
+
+func TestKubernetes(t *testing.T) {
+	Convey("Cgroupfs driver layout should be parsed", t, func() {
+		So(parseKubeCgroup("kubepods/burstable/pod"+testPodUID+"/"+testContainerID), ShouldResemble, map[string]string{
+			"pod_uid":      testPodUID,
+			"qos_class":    "burstable",
+			"container_id": testContainerID,
+		})
+		So(parseKubeCgroup("kubepods/pod"+testPodUID), ShouldResemble, map[string]string{
+			"pod_uid":   testPodUID,
+			"qos_class": "guaranteed",
+		})
+	})
+	Convey("Systemd driver layout should be parsed", t, func() {
+		uid := "1f0b8e3a_4c2d_4e5f_9a7b_2c3d4e5f6a7b"
+		So(parseKubeCgroup("kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod"+uid+".slice/cri-containerd-"+testContainerID+".scope"), ShouldResemble, map[string]string{
+			"pod_uid":      testPodUID,
+			"qos_class":    "besteffort",
+			"container_id": testContainerID,
+		})
+		So(parseKubeCgroup("kubepods.slice/kubepods-pod"+uid+".slice/docker-"+testContainerID+".scope"), ShouldResemble, map[string]string{
+			"pod_uid":      testPodUID,
+			"qos_class":    "guaranteed",
+			"container_id": testContainerID,
+		})
+	})
+	Convey("Cgroups outside of pods should not be parsed", t, func() {
+		So(parseKubeCgroup("system.slice/docker-"+testContainerID+".scope"), ShouldBeNil)
+		So(parseKubeCgroup("kubepods.slice"), ShouldBeNil)
+		So(parseKubeCgroup("kubepods/burstable"), ShouldBeNil)
+		So(parseKubeCgroup("kubepods/unknown/pod"+testPodUID), ShouldBeNil)
+	})
+	Convey("Pod names should be resolved from kubelet pods directory", t, func() {
+		So(readKubeletPod("kubelet/pods", testPodUID), ShouldResemble, map[string]string{
+			"pod_name":      "nginx-7c5ddbdf54-8xk2p",
+			"pod_namespace": "default",
+		})
+		So(readKubeletPod("kubelet/pods", "unknown"), ShouldBeEmpty)
+	})
+	Convey("Pod hostname should be read only from kubelet managed hosts file", t, func() {
+		So(parseKubeHosts([]string{
+			"# Kubernetes-managed hosts file.",
+			"127.0.0.1\tlocalhost",
+			"10.244.1.18\tweb-0.web.default.svc.cluster.local\tweb-0",
+			"",
+			"# Entries added by HostAliases.",
+			"10.1.2.3\tfoo.local\tbar.local",
+		}), ShouldEqual, "web-0")
+		So(parseKubeHosts([]string{
+			"# Kubernetes-managed hosts file (host network).",
+			"127.0.0.1\tlocalhost",
+			"10.0.0.5\tnode-01",
+		}), ShouldBeEmpty)
+		So(parseKubeHosts([]string{"127.0.0.1\tlocalhost"}), ShouldBeEmpty)
+		So(parseKubeHosts([]string{""}), ShouldBeEmpty)
+	})
+	Convey("Container names should be resolved from container state file", t, func() {
+		So(readContainerState("containers/{id}/config.v2.json", testContainerID), ShouldResemble, map[string]string{
+			"pod_name":       "nginx-7c5ddbdf54-8xk2p",
+			"pod_namespace":  "default",
+			"container_name": "nginx",
+		})
+		So(readContainerState("containers/{id}/config.v2.json", "unknown"), ShouldBeEmpty)
+	})
+	Convey("Cgroup tags should include kubernetes identity", t, func() {
+		u := &Use{KubeletPodsPath: "kubelet/pods", ContainerStatePath: "containers/{id}/config.v2.json"}
+		tags := u.cgroupTags("kubepods/burstable/pod" + testPodUID + "/" + testContainerID)
+		So(tags["cgroup_path"], ShouldEqual, "/kubepods/burstable/pod"+testPodUID+"/"+testContainerID)
+		So(tags["pod_name"], ShouldEqual, "nginx-7c5ddbdf54-8xk2p")
+		So(tags["container_name"], ShouldEqual, "nginx")
+		So(tags["qos_class"], ShouldEqual, "burstable")
+		So(u.cgroupTags("system.slice"), ShouldResemble, map[string]string{"cgroup_path": "/system.slice"})
+	})
+	Convey("Kubernetes names should be cached by pod uid and container id", t, func() {
+		u := &Use{KubeletPodsPath: "kubelet/pods", ContainerStatePath: "containers/{id}/config.v2.json", TagsRefresh: time.Hour}
+		cgroup := "kubepods/burstable/pod" + testPodUID + "/" + testContainerID
+		So(u.kubeTags(cgroup)["pod_namespace"], ShouldEqual, "default")
+		u.KubeletPodsPath, u.ContainerStatePath = "/some/pods", "/some/{id}"
+		tags := u.kubeTags(cgroup)
+		So(tags["pod_name"], ShouldEqual, "nginx-7c5ddbdf54-8xk2p")
+		So(tags["container_name"], ShouldEqual, "nginx")
+	})
+}
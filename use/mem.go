@@ -2,25 +2,67 @@ package use
 
 import (
 	"fmt"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
 	"github.com/pkg/errors"
 )
 
+// memInfoMetrics maps memory breakdown metrics to /proc/meminfo fields
+var memInfoMetrics = map[string]string{
+	"cached":             "Cached",
+	"buffers":            "Buffers",
+	"slab_reclaimable":   "SReclaimable",
+	"slab_unreclaimable": "SUnreclaim",
+	"anonymous":          "AnonPages",
+}
+
+// vmStatMetrics maps memory paging and reclaim rate metrics to counters of readStatForVMStat
+var vmStatMetrics = map[string]string{
+	"swap_in":      "SwapIn",
+	"swap_out":     "SwapOut",
+	"major_faults": "MajorFaults",
+	"scan_direct":  "ScanDirect",
+	"scan_kswapd":  "ScanKswapd",
+	"allocstall":   "AllocStall",
+}
+
+// memMetricLabels are names of memory breakdown and paging metrics
+var memMetricLabels = []string{
+	"available",
+	"cached",
+	"buffers",
+	"slab_reclaimable",
+	"slab_unreclaimable",
+	"anonymous",
+	"swap_in",
+	"swap_out",
+	"major_faults",
+	"scan_direct",
+	"scan_kswapd",
+	"allocstall",
+}
+
 // MemInfo struct for storing IO Data
 type MemInfo struct {
-	MemTotal    float64
-	MemFree     float64
-	SwapIn      float64
-	SwapOut     float64
-	vmStatPath  string
-	memInfoPath string
+	MemTotal     float64
+	MemFree      float64
+	MemAvailable float64
+	SwapIn       float64
+	SwapOut      float64
+	last         map[string]int64
+	current      map[string]int64
+	elapsed      time.Duration
+	memInfoPath  string
+	edacPath     string
 }
 
-// Utilization returns utilization of Memory
+// Utilization returns percentage of Memory which is not available for
+// starting new applications without swapping
 func (m *MemInfo) Utilization() (float64, error) {
 	memInfo, err := readStatForMemInfo(m.memInfoPath)
 
@@ -30,26 +72,74 @@ func (m *MemInfo) Utilization() (float64, error) {
 
 	m.MemFree = float64(memInfo["MemFree"])
 	m.MemTotal = float64(memInfo["MemTotal"])
+	m.MemAvailable = float64(memAvailable(memInfo))
 
-	if m.MemTotal >= 0 {
-		return 100.0 - (m.MemFree / m.MemTotal * 100), nil
+	if m.MemTotal > 0 {
+		return 100.0 - (m.MemAvailable / m.MemTotal * 100), nil
 	}
 	return 0.0, errors.Errorf("Error Total Memory is lower or equal 0")
 }
 
-// Saturation returns saturation of Memory
-func (m *MemInfo) Saturation() (float64, error) {
-	memInfo, err := readStatForVMStat(m.vmStatPath)
+// Bytes returns size in bytes of /proc/meminfo field
+func (m *MemInfo) Bytes(field string) (float64, error) {
+	memInfo, err := readStatForMemInfo(m.memInfoPath)
 	if err != nil {
 		return 0.0, err
 	}
+	if field == "MemAvailable" {
+		return float64(memAvailable(memInfo) * 1024), nil
+	}
+	value, ok := memInfo[field]
+	if !ok {
+		return 0.0, errors.Errorf("Unable to find %s in %s", field, m.memInfoPath)
+	}
+	return float64(value * 1024), nil
+}
+
+// memAvailable returns MemAvailable in kB, on kernels older than 3.14 which do
+// not report it, available memory is estimated as free memory and page cache
+// which can be reclaimed
+func memAvailable(memInfo map[string]int64) int64 {
+	if available, ok := memInfo["MemAvailable"]; ok {
+		return available
+	}
+	return memInfo["MemFree"] + memInfo["Buffers"] + memInfo["Cached"] + memInfo["SReclaimable"]
+}
+
+// Saturation returns number of paging and reclaim events per second between
+// last and current measurement: pages swapped in and out, major faults,
+// pages scanned by kswapd and direct reclaim and allocation stalls
+func (m *MemInfo) Saturation() float64 {
+	m.SwapIn = m.Rate("SwapIn")
+	m.SwapOut = m.Rate("SwapOut")
+	return m.SwapIn + m.SwapOut + m.Rate("MajorFaults") + m.Rate("ScanKswapd") + m.Rate("ScanDirect") + m.Rate("AllocStall")
+}
+
+// Rate returns per second change of /proc/vmstat counter between last and current measurement
+func (m *MemInfo) Rate(key string) float64 {
+	if m.elapsed <= 0 || m.current[key] < m.last[key] {
+		return 0.0
+	}
+	return float64(m.current[key]-m.last[key]) / m.elapsed.Seconds()
+}
 
-	m.SwapIn = float64(memInfo["SwapIn"])
-	m.SwapOut = float64(memInfo["SwapOut"])
-	if m.SwapOut > 0 {
-		return m.SwapIn / m.SwapOut * 100.00, nil
+// Errors returns number of corrected and uncorrected errors reported by EDAC memory controllers
+func (m *MemInfo) Errors() (float64, error) {
+	controllers, err := filepath.Glob(filepath.Join(m.edacPath, "mc*"))
+	if err != nil {
+		return 0.0, err
+	}
+	var count int64
+	for _, mc := range controllers {
+		for _, counter := range []string{"ce_count", "ue_count"} {
+			val, err := readInt(filepath.Join(mc, counter))
+			if err != nil {
+				return 0.0, err
+			}
+			count += val
+		}
 	}
-	return 0.0, nil
+	return float64(count), nil
 }
 
 func getMemMetricTypes() ([]plugin.Metric, error) {
@@ -58,13 +148,16 @@ func getMemMetricTypes() ([]plugin.Metric, error) {
 
 		mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "memory", name)})
 	}
+	for _, name := range memMetricLabels {
+		mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "memory", name)})
+	}
 	return mts, nil
 }
 
-func memStat(ns plugin.Namespace, vmStatPath string, memInfoPath string) (*plugin.Metric, error) {
+func (u *Use) memStat(ns plugin.Namespace) (*plugin.Metric, error) {
 	switch {
 	case regexp.MustCompile(`^/intel/use/memory/utilization$`).MatchString(ns.String()):
-		m := MemInfo{vmStatPath: vmStatPath, memInfoPath: memInfoPath}
+		m := MemInfo{memInfoPath: u.MemInfoPath}
 		metric, err := m.Utilization()
 		if err != nil {
 			return nil, errors.Errorf("Unable to get memory utilization: %s", err.Error())
@@ -75,12 +168,59 @@ func memStat(ns plugin.Namespace, vmStatPath string, memInfoPath string) (*plugi
 		}, nil
 
 	case regexp.MustCompile(`^/intel/use/memory/saturation$`).MatchString(ns.String()):
-		m := MemInfo{vmStatPath: vmStatPath, memInfoPath: memInfoPath}
-		metric, err := m.Saturation()
+		metric, err := u.memRate(ns, (*MemInfo).Saturation)
 		if err != nil {
 			return nil, errors.Errorf("Unable to get memory saturation: %s", err.Error())
 		}
 
+		return &plugin.Metric{
+			Namespace: ns,
+			Data:      metric,
+		}, nil
+
+	case regexp.MustCompile(`^/intel/use/memory/errors$`).MatchString(ns.String()):
+		m := MemInfo{edacPath: u.EdacPath}
+		metric, err := m.Errors()
+		if err != nil {
+			return nil, errors.Errorf("Unable to get memory errors: %s", err.Error())
+		}
+
+		return &plugin.Metric{
+			Namespace: ns,
+			Data:      metric,
+		}, nil
+
+	case regexp.MustCompile(`^/intel/use/memory/available$`).MatchString(ns.String()):
+		m := MemInfo{memInfoPath: u.MemInfoPath}
+		metric, err := m.Bytes("MemAvailable")
+		if err != nil {
+			return nil, errors.Errorf("Unable to get available memory: %s", err.Error())
+		}
+
+		return &plugin.Metric{
+			Namespace: ns,
+			Data:      metric,
+		}, nil
+	}
+
+	if field, ok := memInfoMetrics[ns.Strings()[len(ns)-1]]; ok {
+		m := MemInfo{memInfoPath: u.MemInfoPath}
+		metric, err := m.Bytes(field)
+		if err != nil {
+			return nil, errors.Errorf("Unable to get memory %s: %s", ns.Strings()[len(ns)-1], err.Error())
+		}
+
+		return &plugin.Metric{
+			Namespace: ns,
+			Data:      metric,
+		}, nil
+	}
+	if counter, ok := vmStatMetrics[ns.Strings()[len(ns)-1]]; ok {
+		metric, err := u.memRate(ns, func(m *MemInfo) float64 { return m.Rate(counter) })
+		if err != nil {
+			return nil, errors.Errorf("Unable to get memory %s: %s", ns.Strings()[len(ns)-1], err.Error())
+		}
+
 		return &plugin.Metric{
 			Namespace: ns,
 			Data:      metric,
@@ -89,61 +229,87 @@ func memStat(ns plugin.Namespace, vmStatPath string, memInfoPath string) (*plugi
 	return nil, fmt.Errorf("Unknown memory namespace processing %v", ns)
 }
 
+// memRate computes metric of /proc/vmstat counters between previous and current collection of namespace
+func (u *Use) memRate(ns plugin.Namespace, rate func(*MemInfo) float64) (float64, error) {
+	current, err := readStatForVMStat(u.VmStatPath)
+	if err != nil {
+		return 0.0, err
+	}
+	last, elapsed, ok := u.swapSnapshot(ns, current)
+	if !ok {
+		return 0.0, nil
+	}
+	m := MemInfo{last: last.counters, current: current, elapsed: elapsed}
+	return rate(&m), nil
+}
+
 func readStatForMemInfo(memInfoPath string) (map[string]int64, error) {
 	lines, err := readLines(memInfoPath)
-	ret := make(map[string]int64, 2)
+	ret := make(map[string]int64)
 	if err != nil {
 		return ret, err
 	}
 	for _, line := range lines {
 		fields := strings.Fields(line)
-		if len(fields) > 0 {
-			key := strings.TrimSpace(fields[0])
+		if len(fields) > 1 {
+			key := strings.TrimSuffix(strings.TrimSpace(fields[0]), ":")
 			value := strings.TrimSpace(fields[1])
-			switch key {
-			case "MemTotal:":
-				ret["MemTotal"], err = strconv.ParseInt(value, 10, 64)
-				if err != nil {
-					return ret, errors.Errorf("Unable to parse int from mem total %s: %s", value, err.Error())
-				}
-			case "MemFree:":
-				ret["MemFree"], err = strconv.ParseInt(value, 10, 64)
-				if err != nil {
-					return ret, errors.Errorf("Unable to parse int from mem free %s: %s", value, err.Error())
-				}
+			ret[key], err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return ret, errors.Errorf("Unable to parse int from %s %s: %s", key, value, err.Error())
 			}
 		}
 	}
 	return ret, nil
 }
 
+// readStatForVMStat returns paging and reclaim counters from /proc/vmstat,
+// per zone counters of older kernels are summed up
 func readStatForVMStat(vmStatPath string) (map[string]int64, error) {
 	filename := vmStatPath
-	ret := make(map[string]int64, 2)
+	ret := make(map[string]int64)
 	lines, err := readLines(filename)
 	if err != nil {
 		return ret, err
 	}
 
+	for _, key := range vmStatMetrics {
+		ret[key] = 0
+	}
 	for _, line := range lines {
 		fields := strings.Fields(line)
-		if len(fields) > 0 {
-			key := strings.TrimSpace(fields[0])
-			value := strings.TrimSpace(fields[1])
-			switch key {
-			case "pswpin":
-				ret["SwapIn"], err = strconv.ParseInt(value, 10, 64)
-				if err != nil {
-					return nil, err
-				}
-			case "pswpout":
-				ret["SwapOut"], err = strconv.ParseInt(value, 10, 64)
-				if err != nil {
-					return nil, err
-				}
+		if len(fields) > 1 {
+			key := vmStatCounter(strings.TrimSpace(fields[0]))
+			if key == "" {
+				continue
 			}
-
+			value, err := strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			ret[key] += value
 		}
 	}
 	return ret, nil
 }
+
+// vmStatCounter returns name of counter which /proc/vmstat field is accounted to
+func vmStatCounter(field string) string {
+	switch {
+	case field == "pswpin":
+		return "SwapIn"
+	case field == "pswpout":
+		return "SwapOut"
+	case field == "pgmajfault":
+		return "MajorFaults"
+	case field == "pgscan_direct_throttle":
+		return ""
+	case field == "pgscan_direct" || strings.HasPrefix(field, "pgscan_direct_"):
+		return "ScanDirect"
+	case field == "pgscan_kswapd" || strings.HasPrefix(field, "pgscan_kswapd_"):
+		return "ScanKswapd"
+	case field == "allocstall" || strings.HasPrefix(field, "allocstall_"):
+		return "AllocStall"
+	}
+	return ""
+}
@@ -22,59 +22,108 @@ limitations under the License.
 package use
 
 import (
-	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
 
 func TestMemoryUsePlugin(t *testing.T) {
-	procPath = "proc"
-	memInfoPath = filepath.Join(procPath, "meminfo")
 	Convey("Read memory data should return proper MemInfo", t, func() {
-		file, err := readStatForMemInfo()
-		So(file, ShouldResemble, map[string]int64{"MemTotal": 16310784, "MemFree": 15344340})
+		file, err := readStatForMemInfo("proc/meminfo")
+		So(file["MemTotal"], ShouldEqual, 16310784)
+		So(file["MemFree"], ShouldEqual, 15344340)
+		So(file["MemAvailable"], ShouldEqual, 15857844)
+		So(file["SReclaimable"], ShouldEqual, 84508)
+		So(file["Active(anon)"], ShouldEqual, 168720)
+		So(file["HugePages_Total"], ShouldEqual, 0)
+		So(len(file), ShouldEqual, 46)
 		So(err, ShouldBeNil)
 
 	})
-	procPath = "/some/proc"
-	memInfoPath = filepath.Join(procPath, "meminfo")
 	Convey("Read memory data when file not available should return error", t, func() {
-		file, err := readStatForMemInfo()
+		file, err := readStatForMemInfo("/some/proc/meminfo")
 		So(file, ShouldResemble, map[string]int64{})
-		So(err.Error(), ShouldResemble, "open /some/proc/meminfo: no such file or directory")
+		So(err.Error(), ShouldResemble, "Unable to open file /some/proc/meminfo: open /some/proc/meminfo: no such file or directory")
 
 	})
-	procPath = "proc"
-	vmStatPath = filepath.Join(procPath, "vmstat")
 	Convey("Read vm memory data should return proper MemInfo", t, func() {
-		file, err := readStatForVMStat()
-		So(file, ShouldResemble, map[string]int64{"SwapIn": 0, "SwapOut": 10.0})
+		file, err := readStatForVMStat("proc/vmstat")
+		So(file, ShouldResemble, map[string]int64{"SwapIn": 0, "SwapOut": 10.0, "MajorFaults": 2070, "ScanDirect": 0, "ScanKswapd": 0, "AllocStall": 0})
 		So(err, ShouldBeNil)
 
 	})
-	procPath = "/some/proc"
-	vmStatPath = filepath.Join(procPath, "vmstat")
 	Convey("Read vm memory data when file not available should return error", t, func() {
-		file, err := readStatForVMStat()
+		file, err := readStatForVMStat("/some/proc/vmstat")
 		So(file, ShouldResemble, map[string]int64{})
-		So(err.Error(), ShouldResemble, "open /some/proc/vmstat: no such file or directory")
+		So(err.Error(), ShouldResemble, "Unable to open file /some/proc/vmstat: open /some/proc/vmstat: no such file or directory")
 
 	})
-	procPath = "proc"
-	vmStatPath = filepath.Join(procPath, "vmstat")
-	memInfoPath = filepath.Join(procPath, "meminfo")
 	Convey("get Utilization should return proper value", t, func() {
-		m := MemInfo{}
+		m := MemInfo{memInfoPath: "proc/meminfo"}
 		utilization, err := m.Utilization()
-		So(utilization, ShouldResemble, 5.925184221678123)
+		So(utilization, ShouldAlmostEqual, 2.776935799039464)
 		So(err, ShouldBeNil)
 	})
 
-	Convey("get Saturation should return proper value", t, func() {
-		m := MemInfo{}
-		saturation, err := m.Saturation()
-		So(saturation, ShouldResemble, 0.0)
+	Convey("Available memory should be estimated on kernels without MemAvailable", t, func() {
+		available := memAvailable(map[string]int64{"MemFree": 1000, "Buffers": 100, "Cached": 500, "SReclaimable": 50})
+		So(available, ShouldEqual, 1650)
+	})
+
+	Convey("get Bytes should return size of meminfo field in bytes", t, func() {
+		m := MemInfo{memInfoPath: "proc/meminfo"}
+		cached, err := m.Bytes("Cached")
+		So(err, ShouldBeNil)
+		So(cached, ShouldResemble, 464232.0*1024)
+		available, err := m.Bytes("MemAvailable")
+		So(err, ShouldBeNil)
+		So(available, ShouldResemble, 15857844.0*1024)
+		_, err = m.Bytes("Unknown")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("get Saturation should return rate of paging and reclaim events", t, func() {
+		m := MemInfo{
+			last:    map[string]int64{"SwapIn": 0, "SwapOut": 10, "MajorFaults": 2070, "ScanDirect": 0, "ScanKswapd": 0, "AllocStall": 0},
+			current: map[string]int64{"SwapIn": 20, "SwapOut": 50, "MajorFaults": 2110, "ScanDirect": 100, "ScanKswapd": 400, "AllocStall": 2},
+			elapsed: 2 * time.Second,
+		}
+		So(m.Saturation(), ShouldResemble, 301.0)
+		So(m.SwapIn, ShouldResemble, 10.0)
+		So(m.SwapOut, ShouldResemble, 20.0)
+		So(m.Rate("MajorFaults"), ShouldResemble, 20.0)
+		So(m.Rate("ScanDirect"), ShouldResemble, 50.0)
+	})
+
+	Convey("get Saturation without elapsed time should return zero", t, func() {
+		m := MemInfo{
+			last:    map[string]int64{"SwapIn": 0},
+			current: map[string]int64{"SwapIn": 20},
+		}
+		So(m.Saturation(), ShouldResemble, 0.0)
+	})
+
+	Convey("Per zone vmstat counters should be summed", t, func() {
+		So(vmStatCounter("pgscan_direct_normal"), ShouldEqual, "ScanDirect")
+		So(vmStatCounter("pgscan_direct"), ShouldEqual, "ScanDirect")
+		So(vmStatCounter("pgscan_direct_throttle"), ShouldEqual, "")
+		So(vmStatCounter("pgscan_kswapd_dma32"), ShouldEqual, "ScanKswapd")
+		So(vmStatCounter("allocstall_movable"), ShouldEqual, "AllocStall")
+		So(vmStatCounter("pgfault"), ShouldEqual, "")
+	})
+
+	Convey("get Errors should return proper value", t, func() {
+		m := MemInfo{edacPath: "sys/devices/system/edac/mc"}
+		errs, err := m.Errors()
+		So(errs, ShouldResemble, 1.0)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("get Errors without EDAC should return zero", t, func() {
+		m := MemInfo{edacPath: "/some/sys/devices/system/edac/mc"}
+		errs, err := m.Errors()
+		So(errs, ShouldResemble, 0.0)
 		So(err, ShouldBeNil)
 	})
 }
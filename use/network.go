@@ -0,0 +1,252 @@
+package use
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+	"github.com/pkg/errors"
+)
+
+// NetStat struct for storing network interface metric Data
+type NetStat struct {
+	last       map[string]int64
+	current    map[string]int64
+	elapsed    time.Duration
+	ifaceName  string
+	netDevPath string
+}
+
+// Utilization returns percentage of link speed (in Mbit/s) used by Network Device
+// between last and current measurement
+func (n *NetStat) Utilization(speed int64) float64 {
+	if n.elapsed <= 0 || speed <= 0 {
+		return 0.0
+	}
+	bytes := n.delta("rx_bytes") + n.delta("tx_bytes")
+	capacity := float64(speed) * 1000000.0 / 8.0 * n.elapsed.Seconds()
+	return 100.0 * bytes / capacity
+}
+
+// Saturation returns percentage of dropped and overrun packets of Network Device
+// between last and current measurement
+func (n *NetStat) Saturation() float64 {
+	packets := n.delta("rx_packets") + n.delta("tx_packets")
+	if packets <= 0 {
+		return 0.0
+	}
+	dropped := n.delta("rx_drop") + n.delta("rx_fifo") + n.delta("tx_drop") + n.delta("tx_fifo")
+	return 100.0 * dropped / packets
+}
+
+// Errors returns number of receive and transmit errors of Network Device
+func (n *NetStat) Errors() (float64, error) {
+	stat, err := readStatForNetDev(n.ifaceName, n.netDevPath)
+	if err != nil {
+		return 0.0, err
+	}
+	return float64(stat["rx_errs"] + stat["tx_errs"]), nil
+}
+
+func (n *NetStat) delta(key string) float64 {
+	if n.current[key] < n.last[key] {
+		// counter was reset
+		return 0.0
+	}
+	return float64(n.current[key] - n.last[key])
+}
+
+func readLinkSpeed(ifaceName string, sysPath string) (int64, error) {
+	speed, err := readInt(filepath.Join(sysPath, "class", "net", ifaceName, "speed"))
+	if err != nil {
+		return 0, errors.Errorf("Unable to read link speed of %s: %s", ifaceName, err.Error())
+	}
+	if speed <= 0 {
+		return 0, errors.Errorf("Unknown link speed of %s", ifaceName)
+	}
+	return speed, nil
+}
+
+// getNetMetricTypes returns metrics of network interfaces, utilization is
+// published only for interfaces which report link speed
+func getNetMetricTypes(netDevPath string, sysPath string, filter *deviceFilter) ([]plugin.Metric, error) {
+	var mts []plugin.Metric
+
+	ifaces, err := listNetDevices(netDevPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, ifaceName := range filter.filter(ifaces) {
+		for _, name := range metricLabels {
+			if name == "utilization" {
+				if _, err := readLinkSpeed(ifaceName, sysPath); err != nil {
+					log.Infof("Skipping network utilization: %s", err.Error())
+					continue
+				}
+			}
+			mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "network", ifaceName, name)})
+		}
+	}
+	return mts, nil
+}
+
+func listNetDevices(netDevPath string) ([]string, error) {
+	lines, err := readLines(netDevPath)
+	if err != nil {
+		return nil, err
+	}
+	ifaces := []string{}
+	for _, line := range lines {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			// header lines do not contain interface name separator
+			continue
+		}
+		iface := strings.TrimSpace(parts[0])
+		if iface == "lo" {
+			continue
+		}
+		ifaces = append(ifaces, iface)
+	}
+	return ifaces, nil
+}
+
+func readStatForNetDev(ifaceName string, netDevPath string) (map[string]int64, error) {
+	lines, err := readLines(netDevPath)
+	if err != nil {
+		return nil, err
+	}
+	entries := []string{
+		"rx_bytes", "rx_packets", "rx_errs", "rx_drop", "rx_fifo", "rx_frame", "rx_compressed", "rx_multicast",
+		"tx_bytes", "tx_packets", "tx_errs", "tx_drop", "tx_fifo", "tx_colls", "tx_carrier", "tx_compressed",
+	}
+	for _, line := range lines {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != ifaceName {
+			continue
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) < len(entries) {
+			return nil, errors.Errorf("Unexpected number of fields for interface %s", ifaceName)
+		}
+		stat := map[string]int64{}
+		for i, entry := range entries {
+			stat[entry], err = strconv.ParseInt(fields[i], 10, 64)
+			if err != nil {
+				return nil, errors.Errorf("Unable to parse %s of interface %s: %s", entry, ifaceName, err.Error())
+			}
+		}
+		return stat, nil
+	}
+
+	return nil, fmt.Errorf("Can't find a network interface %s.\n", ifaceName)
+}
+
+func (u *Use) netStat(ns plugin.Namespace) (*plugin.Metric, error) {
+	ifaceName := ns.Strings()[3]
+	switch {
+	case regexp.MustCompile(`^/intel/use/network/.*/utilization$`).MatchString(ns.String()):
+		speed, err := readLinkSpeed(ifaceName, u.SysPath)
+		if err != nil {
+			return nil, errors.Errorf("Unable to get network utilization: %s", err.Error())
+		}
+		current, err := readStatForNetDev(ifaceName, u.NetDevPath)
+		if err != nil {
+			return nil, errors.Errorf("Unable to get network utilization: %s", err.Error())
+		}
+		var metric float64
+		if last, elapsed, ok := u.swapSnapshot(ns, current); ok {
+			netStat := NetStat{last: last.counters, current: current, elapsed: elapsed}
+			metric = netStat.Utilization(speed)
+		}
+		return &plugin.Metric{
+			Namespace: ns,
+			Data:      metric,
+		}, nil
+	case regexp.MustCompile(`^/intel/use/network/.*/saturation$`).MatchString(ns.String()):
+		current, err := readStatForNetDev(ifaceName, u.NetDevPath)
+		if err != nil {
+			return nil, errors.Errorf("Unable to get network saturation: %s", err.Error())
+		}
+		var metric float64
+		if last, elapsed, ok := u.swapSnapshot(ns, current); ok {
+			netStat := NetStat{last: last.counters, current: current, elapsed: elapsed}
+			metric = netStat.Saturation()
+		}
+		return &plugin.Metric{
+			Namespace: ns,
+			Data:      metric,
+		}, nil
+	case regexp.MustCompile(`^/intel/use/network/.*/errors$`).MatchString(ns.String()):
+		netStat := NetStat{ifaceName: ifaceName, netDevPath: u.NetDevPath}
+		metric, err := netStat.Errors()
+		if err != nil {
+			return nil, errors.Errorf("Unable to get network errors: %s", err.Error())
+		}
+		return &plugin.Metric{
+			Namespace: ns,
+			Data:      metric,
+		}, nil
+	}
+
+	return nil, errors.Errorf("Unknown network stat namespace %v", ns)
+}
+
+// readNetTags returns metadata of network interface from sysfs, properties
+// which are not available for the interface are skipped
+func readNetTags(ifaceName string, sysPath string) map[string]string {
+	ifacePath := filepath.Join(sysPath, "class", "net", ifaceName)
+	tags := map[string]string{"kind": netKind(ifacePath)}
+
+	for tag, file := range map[string]string{"mac": "address", "mtu": "mtu", "operstate": "operstate", "duplex": "duplex"} {
+		if value, err := readString(filepath.Join(ifacePath, file)); err == nil && value != "" {
+			tags[tag] = value
+		}
+	}
+	if speed, err := readLinkSpeed(ifaceName, sysPath); err == nil {
+		tags["speed"] = strconv.FormatInt(speed, 10)
+	}
+	if driver, err := os.Readlink(filepath.Join(ifacePath, "device", "driver")); err == nil {
+		tags["driver"] = filepath.Base(driver)
+	}
+	if master, err := os.Readlink(filepath.Join(ifacePath, "master")); err == nil {
+		tags["master"] = filepath.Base(master)
+	}
+	return tags
+}
+
+// netKind returns kind of network interface: bond, bridge, vlan, physical,
+// veth for interfaces linked to a peer, or virtual
+func netKind(ifacePath string) string {
+	if _, err := os.Stat(filepath.Join(ifacePath, "bonding")); err == nil {
+		return "bond"
+	}
+	if _, err := os.Stat(filepath.Join(ifacePath, "bridge")); err == nil {
+		return "bridge"
+	}
+	if lines, err := readLines(filepath.Join(ifacePath, "uevent")); err == nil {
+		for _, line := range lines {
+			if line == "DEVTYPE=vlan" {
+				return "vlan"
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(ifacePath, "device")); err == nil {
+		return "physical"
+	}
+	ifindex, err := readString(filepath.Join(ifacePath, "ifindex"))
+	if err != nil {
+		return "virtual"
+	}
+	iflink, err := readString(filepath.Join(ifacePath, "iflink"))
+	if err == nil && iflink != ifindex {
+		return "veth"
+	}
+	return "virtual"
+}
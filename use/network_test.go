@@ -0,0 +1,129 @@
+//
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package use
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNetworkUsePlugin(t *testing.T) {
+	Convey("List network devices should skip loopback", t, func() {
+		ifaces, err := listNetDevices("proc/net/dev")
+		So(err, ShouldBeNil)
+		So(ifaces, ShouldResemble, []string{"eth0", "docker0"})
+	})
+	Convey("Utilization should be listed only for interfaces with link speed", t, func() {
+		f, err := newDeviceFilter(plugin.Config{}, "network", "sys/class/net")
+		So(err, ShouldBeNil)
+		mts, err := getNetMetricTypes("proc/net/dev", "sys", f)
+		So(err, ShouldBeNil)
+		names := []string{}
+		for _, m := range mts {
+			names = append(names, m.Namespace.Strings()[3]+"/"+m.Namespace.Strings()[4])
+		}
+		So(names, ShouldContain, "eth0/utilization")
+		So(names, ShouldNotContain, "docker0/utilization")
+		So(names, ShouldContain, "docker0/saturation")
+	})
+	Convey("Read network data should return proper counters", t, func() {
+		stat, err := readStatForNetDev("eth0", "proc/net/dev")
+		So(err, ShouldBeNil)
+		So(stat["rx_bytes"], ShouldEqual, 93482716)
+		So(stat["rx_drop"], ShouldEqual, 12)
+		So(stat["rx_fifo"], ShouldEqual, 3)
+		So(stat["tx_bytes"], ShouldEqual, 5736125)
+		So(stat["tx_packets"], ShouldEqual, 49871)
+		So(stat["tx_drop"], ShouldEqual, 4)
+	})
+	Convey("Read network data of unknown interface should return error", t, func() {
+		_, err := readStatForNetDev("eth9", "proc/net/dev")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Read network data when file not available should return error", t, func() {
+		_, err := readStatForNetDev("eth0", "/some/proc/net/dev")
+		So(err.Error(), ShouldResemble, "Unable to open file /some/proc/net/dev: open /some/proc/net/dev: no such file or directory")
+	})
+	Convey("get Utilization should return proper value", t, func() {
+		n := NetStat{
+			last:    map[string]int64{"rx_bytes": 1000000, "tx_bytes": 250000},
+			current: map[string]int64{"rx_bytes": 3500000, "tx_bytes": 1000000},
+			elapsed: 2 * time.Second,
+		}
+		speed, err := readLinkSpeed("eth0", "sys")
+		So(err, ShouldBeNil)
+		So(speed, ShouldEqual, 10000)
+		So(n.Utilization(speed), ShouldAlmostEqual, 0.13)
+	})
+	Convey("read link speed without speed file should return error", t, func() {
+		_, err := readLinkSpeed("eth0", "/some/sys")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("get Saturation should return proper value", t, func() {
+		n := NetStat{
+			last:    map[string]int64{"rx_packets": 1000, "tx_packets": 1000, "rx_drop": 0, "tx_fifo": 0},
+			current: map[string]int64{"rx_packets": 1150, "tx_packets": 1050, "rx_drop": 3, "tx_fifo": 1},
+			elapsed: time.Second,
+		}
+		So(n.Saturation(), ShouldResemble, 2.0)
+	})
+	Convey("get Saturation without traffic should return zero", t, func() {
+		n := NetStat{last: map[string]int64{}, current: map[string]int64{}, elapsed: time.Second}
+		So(n.Saturation(), ShouldResemble, 0.0)
+	})
+	Convey("get Errors should return proper value", t, func() {
+		n := NetStat{ifaceName: "eth0", netDevPath: "proc/net/dev"}
+		errs, err := n.Errors()
+		So(errs, ShouldResemble, 6.0)
+		So(err, ShouldBeNil)
+	})
+	Convey("Network interface kind should be detected from sysfs", t, func() {
+		So(netKind("sys/class/net/eth0"), ShouldEqual, "physical")
+		So(netKind("sys/class/net/docker0"), ShouldEqual, "bridge")
+		So(netKind("sys/class/net/bond0"), ShouldEqual, "bond")
+		So(netKind("sys/class/net/eth0.100"), ShouldEqual, "vlan")
+		So(netKind("sys/class/net/veth1a2b3c"), ShouldEqual, "veth")
+		So(netKind("sys/class/net/dummy0"), ShouldEqual, "virtual")
+	})
+	Convey("Network interface tags should be read from sysfs", t, func() {
+		So(readNetTags("eth0", "sys"), ShouldResemble, map[string]string{
+			"kind":      "physical",
+			"mac":       "00:1e:67:a2:4c:10",
+			"mtu":       "1500",
+			"operstate": "up",
+			"duplex":    "full",
+			"speed":     "10000",
+			"driver":    "ixgbe",
+			"master":    "bond0",
+		})
+		So(readNetTags("docker0", "sys"), ShouldResemble, map[string]string{
+			"kind":      "bridge",
+			"mac":       "02:42:5e:1a:3b:7c",
+			"mtu":       "1500",
+			"operstate": "up",
+		})
+	})
+}
@@ -0,0 +1,417 @@
+package use
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+	"github.com/pkg/errors"
+)
+
+// clockTicks is USER_HZ used by /proc/[pid]/stat times, it is 100 on all
+// architectures supported by snap
+const clockTicks = 100
+
+// processBaselineMaxAge is age of the latest scan after which it is not used
+// as baseline of the next scan, unless two scan intervals are longer, usage
+// over such a long interval, e.g. since discovery or a paused task, would be
+// reported as a single collection interval
+const processBaselineMaxAge = 15 * time.Minute
+
+// processMetrics are names of metrics by which top processes are published
+var processMetrics = []string{"cpu_utilization", "rss", "major_faults", "read_bytes_per_sec", "write_bytes_per_sec"}
+
+// processSample contains counters of process read during single scan
+type processSample struct {
+	pid  string
+	ppid string
+	comm string
+	uid  string
+	// start is time process started after boot in clock ticks, it
+	// distinguishes processes with reused pid
+	start int64
+	cpu   int64
+	// childCPU is cpu time of children reaped by process, io counters
+	// include reaped children already
+	childCPU   int64
+	majorFault int64
+	rss        int64
+	readBytes  int64
+	writeBytes int64
+}
+
+// ProcessStat contains resource usage of process between two scans
+type ProcessStat struct {
+	PID  string
+	Comm string
+	UID  string
+	User string
+	// Values are keyed by processMetrics
+	Values map[string]float64
+}
+
+// processUsage contains resources consumed by process and children it
+// reaped between two scans, cpu is in clock ticks
+type processUsage struct {
+	cpu        int64
+	readBytes  int64
+	writeBytes int64
+}
+
+// processScan is result of the latest scan of processes
+type processScan struct {
+	timestamp time.Time
+	// elapsed is time since previous scan, it is 0 for the first scan
+	elapsed time.Duration
+	// uptime is time since boot in seconds when scan was taken
+	uptime  float64
+	samples map[string]processSample
+	stats   []ProcessStat
+	// usage is keyed by pid
+	usage map[string]processUsage
+}
+
+// baseline returns sample of process to compare current sample with and
+// time elapsed since it, process started after previous scan is compared to
+// empty sample taken at its start, nil is returned for unknown baseline
+func baseline(last *processScan, current processSample, uptime float64, elapsed time.Duration) (*processSample, time.Duration) {
+	if last == nil {
+		return nil, 0
+	}
+	if sample, ok := last.samples[current.pid]; ok && sample.start == current.start {
+		return &sample, elapsed
+	}
+	started := float64(current.start) / clockTicks
+	if last.uptime <= 0 || started < last.uptime {
+		// process was missed by previous scan
+		return nil, 0
+	}
+	lifetime := time.Duration((uptime - started) * float64(time.Second))
+	return &processSample{pid: current.pid, start: current.start}, lifetime
+}
+
+// counterDelta returns increase of counter, 0 is returned for reset counter
+func counterDelta(last int64, current int64) int64 {
+	if current < last {
+		return 0
+	}
+	return current - last
+}
+
+// newProcessUsage computes resources consumed by process and children it
+// reaped between last and current sample
+func newProcessUsage(last *processSample, current processSample) processUsage {
+	if last == nil {
+		return processUsage{}
+	}
+	return processUsage{
+		cpu:        counterDelta(last.cpu+last.childCPU, current.cpu+current.childCPU),
+		readBytes:  counterDelta(last.readBytes, current.readBytes),
+		writeBytes: counterDelta(last.writeBytes, current.writeBytes),
+	}
+}
+
+// deductReaped removes usage already accounted to processes which exited
+// since previous scan from usage of their nearest surviving ancestor, which
+// counters include whole usage of reaped descendants
+func deductReaped(last *processScan, samples map[string]processSample, usage map[string]processUsage) {
+	if last == nil {
+		return
+	}
+	for pid, gone := range last.samples {
+		if current, ok := samples[pid]; ok && current.start == gone.start {
+			continue
+		}
+		ancestor := gone.ppid
+		for depth := 0; depth < len(last.samples); depth++ {
+			parent, ok := last.samples[ancestor]
+			if !ok {
+				break
+			}
+			if current, ok := samples[ancestor]; ok && current.start == parent.start {
+				u := usage[ancestor]
+				u.cpu = counterDelta(gone.cpu+gone.childCPU, u.cpu)
+				u.readBytes = counterDelta(gone.readBytes, u.readBytes)
+				u.writeBytes = counterDelta(gone.writeBytes, u.writeBytes)
+				usage[ancestor] = u
+				break
+			}
+			ancestor = parent.ppid
+		}
+	}
+}
+
+// newProcessStat computes usage of process between last and current sample,
+// process without baseline sample reports only resident memory
+func newProcessStat(last *processSample, current processSample, elapsed time.Duration) ProcessStat {
+	stat := ProcessStat{
+		PID:    current.pid,
+		Comm:   current.comm,
+		UID:    current.uid,
+		Values: map[string]float64{"cpu_utilization": 0, "rss": float64(current.rss), "major_faults": 0, "read_bytes_per_sec": 0, "write_bytes_per_sec": 0},
+	}
+	if last == nil || last.start != current.start || elapsed <= 0 {
+		return stat
+	}
+	seconds := elapsed.Seconds()
+	delta := func(last, current int64) float64 {
+		return float64(counterDelta(last, current))
+	}
+	stat.Values["cpu_utilization"] = 100.0 * delta(last.cpu, current.cpu) / clockTicks / seconds
+	stat.Values["major_faults"] = delta(last.majorFault, current.majorFault) / seconds
+	stat.Values["read_bytes_per_sec"] = delta(last.readBytes, current.readBytes) / seconds
+	stat.Values["write_bytes_per_sec"] = delta(last.writeBytes, current.writeBytes) / seconds
+	return stat
+}
+
+// topProcesses returns processes sorted by metric in descending order,
+// ties are ordered by pid to keep ranks stable
+func topProcesses(stats []ProcessStat, metric string) []ProcessStat {
+	sorted := make([]ProcessStat, len(stats))
+	copy(sorted, stats)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Values[metric] != sorted[j].Values[metric] {
+			return sorted[i].Values[metric] > sorted[j].Values[metric]
+		}
+		a, _ := strconv.Atoi(sorted[i].PID)
+		b, _ := strconv.Atoi(sorted[j].PID)
+		return a < b
+	})
+	return sorted
+}
+
+// readProcesses reads samples of all processes in procfs, processes which
+// exit during scan are skipped
+func readProcesses(procPath string) (map[string]processSample, error) {
+	entries, err := ioutil.ReadDir(procPath)
+	if err != nil {
+		return nil, errors.Errorf("Unable to list processes in %s: %s", procPath, err.Error())
+	}
+	samples := map[string]processSample{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		sample, err := readProcess(procPath, entry.Name())
+		if err != nil {
+			log.Debugf("Skipping process %s: %s", entry.Name(), err.Error())
+			continue
+		}
+		samples[sample.pid] = sample
+	}
+	return samples, nil
+}
+
+// readProcess reads counters of process from stat, status and io files,
+// io is readable only with privileges of process owner and is optional
+func readProcess(procPath string, pid string) (processSample, error) {
+	sample := processSample{pid: pid}
+	dir := filepath.Join(procPath, pid)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return sample, err
+	}
+	// comm may contain spaces and parentheses, it ends with last ')'
+	stat := string(data)
+	open, end := strings.Index(stat, "("), strings.LastIndex(stat, ")")
+	if open < 0 || end < open {
+		return sample, errors.Errorf("Unexpected format of %s/stat", dir)
+	}
+	sample.comm = stat[open+1 : end]
+	// fields after comm start with state, which is field 3 of proc(5)
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return sample, errors.Errorf("Unexpected number of fields in %s/stat", dir)
+	}
+	sample.ppid = fields[1]
+	var utime, stime, cutime, cstime int64
+	for _, field := range []struct {
+		index int
+		value *int64
+	}{{9, &sample.majorFault}, {11, &utime}, {12, &stime}, {13, &cutime}, {14, &cstime}, {19, &sample.start}} {
+		*field.value, err = strconv.ParseInt(fields[field.index], 10, 64)
+		if err != nil {
+			return sample, errors.Errorf("Unable to parse %s/stat: %s", dir, err.Error())
+		}
+	}
+	sample.cpu = utime + stime
+	sample.childCPU = cutime + cstime
+
+	lines, err := readLines(filepath.Join(dir, "status"))
+	if err != nil {
+		return sample, err
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "Uid:":
+			sample.uid = fields[1]
+		case "VmRSS:":
+			rss, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return sample, errors.Errorf("Unable to parse VmRSS of process %s: %s", pid, err.Error())
+			}
+			sample.rss = rss * 1024
+		}
+	}
+
+	if io, err := readKeyValues(filepath.Join(dir, "io")); err == nil {
+		sample.readBytes = io["read_bytes:"]
+		sample.writeBytes = io["write_bytes:"]
+	}
+	return sample, nil
+}
+
+// readPasswd returns user names keyed by uid from passwd file
+func readPasswd(passwdPath string) (map[string]string, error) {
+	lines, err := readLines(passwdPath)
+	if err != nil {
+		return nil, err
+	}
+	users := map[string]string{}
+	for _, line := range lines {
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, ok := users[fields[2]]; !ok {
+			users[fields[2]] = fields[0]
+		}
+	}
+	return users, nil
+}
+
+// userName returns name of user with uid, uid is returned for unknown user
+func userName(users map[string]string, uid string) string {
+	if name, ok := users[uid]; ok {
+		return name
+	}
+	return uid
+}
+
+// readUptime returns time since boot in seconds
+func readUptime(procPath string) (float64, error) {
+	uptime, err := readString(filepath.Join(procPath, "uptime"))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(uptime)
+	if len(fields) == 0 {
+		return 0, errors.Errorf("Unexpected format of %s/uptime", procPath)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// scanProcesses returns usage of processes between two latest scans,
+// processes are scanned again when the latest scan is older than scan interval
+func (u *Use) scanProcesses() (*processScan, error) {
+	u.processMutex.Lock()
+	defer u.processMutex.Unlock()
+
+	now := time.Now()
+	if u.processes != nil && now.Sub(u.processes.timestamp) < u.ProcessScanInterval {
+		return u.processes, nil
+	}
+	samples, err := readProcesses(u.ProcPath)
+	if err != nil {
+		return nil, err
+	}
+	users, err := readPasswd(u.PasswdPath)
+	if err != nil {
+		log.Warnf("Unable to resolve user names: %s", err.Error())
+	}
+
+	scan := &processScan{timestamp: now, samples: samples, usage: map[string]processUsage{}}
+	maxAge := processBaselineMaxAge
+	if 2*u.ProcessScanInterval > maxAge {
+		maxAge = 2 * u.ProcessScanInterval
+	}
+	if u.processes != nil && now.Sub(u.processes.timestamp) > maxAge {
+		log.Debugf("Previous process scan is older than %s, starting with new baseline", maxAge.String())
+		u.processes = nil
+	}
+	if u.processes != nil {
+		scan.elapsed = now.Sub(u.processes.timestamp)
+	}
+	scan.uptime, err = readUptime(u.ProcPath)
+	if err != nil {
+		log.Warnf("Unable to detect processes started since previous scan: %s", err.Error())
+	}
+	for _, current := range samples {
+		last, elapsed := baseline(u.processes, current, scan.uptime, scan.elapsed)
+		stat := newProcessStat(last, current, elapsed)
+		stat.User = userName(users, stat.UID)
+		scan.stats = append(scan.stats, stat)
+		scan.usage[current.pid] = newProcessUsage(last, current)
+	}
+	deductReaped(u.processes, samples, scan.usage)
+	u.processes = scan
+	return scan, nil
+}
+
+func getProcessMetricTypes(topN int) []plugin.Metric {
+	var mts []plugin.Metric
+	for _, name := range processMetrics {
+		for rank := 1; rank <= topN; rank++ {
+			mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "process", name, strconv.Itoa(rank))})
+		}
+	}
+	return mts
+}
+
+func isProcessMetric(name string) bool {
+	for _, m := range processMetrics {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *Use) processStat(ns plugin.Namespace) (*plugin.Metric, error) {
+	if len(ns) != 5 {
+		return nil, errors.Errorf("Unknown process stat namespace %v", ns)
+	}
+	name := ns.Strings()[3]
+	if !isProcessMetric(name) {
+		return nil, errors.Errorf("Unknown process stat namespace %v", ns)
+	}
+	if !u.processScanner {
+		return nil, errors.Errorf("Process scanner is disabled, set process_scanner to enable it")
+	}
+	rank, err := strconv.Atoi(ns.Strings()[4])
+	if err != nil || rank < 1 {
+		return nil, errors.Errorf("Invalid process rank %s", ns.Strings()[4])
+	}
+	scan, err := u.scanProcesses()
+	if err != nil {
+		return nil, errors.Errorf("Unable to get process stat: %s", err.Error())
+	}
+
+	if name != "rss" && scan.elapsed == 0 {
+		// rates of the first scan are unknown and all processes would tie
+		log.Debugf("No previous process scan for %s, skipping warm-up value", ns.String())
+		u.markWarmUp(ns)
+	}
+
+	metric := &plugin.Metric{Namespace: ns, Data: 0.0}
+	top := topProcesses(scan.stats, name)
+	if rank <= len(top) {
+		p := top[rank-1]
+		metric.Data = p.Values[name]
+		metric.Tags = map[string]string{"pid": p.PID, "comm": p.Comm, "uid": p.UID, "user": p.User}
+	}
+	return metric, nil
+}
@@ -0,0 +1,182 @@
+//
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package use
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestProcess(t *testing.T) {
+	Convey("Process counters should be read from procfs", t, func() {
+		sample, err := readProcess("proc", "812")
+		So(err, ShouldBeNil)
+		So(sample, ShouldResemble, processSample{
+			pid:        "812",
+			ppid:       "1",
+			comm:       "tmux: server",
+			uid:        "1000",
+			start:      4200,
+			cpu:        200,
+			childCPU:   40,
+			majorFault: 2,
+			rss:        4096 * 1024,
+			readBytes:  0,
+			writeBytes: 8192,
+		})
+		sample, err = readProcess("proc", "2048")
+		So(err, ShouldBeNil)
+		So(sample.readBytes, ShouldEqual, 0)
+		_, err = readProcess("proc", "99999")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("All processes should be scanned", t, func() {
+		samples, err := readProcesses("proc")
+		So(err, ShouldBeNil)
+		So(len(samples), ShouldEqual, 4)
+		So(samples, ShouldContainKey, "1422")
+		_, err = readProcesses("/some/proc")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Process usage should be computed between scans", t, func() {
+		last := processSample{pid: "1", start: 2, cpu: 100, majorFault: 10, rss: 1024, readBytes: 0, writeBytes: 100}
+		current := processSample{pid: "1", comm: "init", uid: "0", start: 2, cpu: 150, majorFault: 20, rss: 2048, readBytes: 4096, writeBytes: 100}
+		stat := newProcessStat(&last, current, 2*time.Second)
+		So(stat.Values, ShouldResemble, map[string]float64{"cpu_utilization": 25.0, "rss": 2048.0, "major_faults": 5.0, "read_bytes_per_sec": 2048.0, "write_bytes_per_sec": 0.0})
+		reused := processSample{pid: "1", start: 3, cpu: 150, rss: 2048}
+		stat = newProcessStat(&last, reused, 2*time.Second)
+		So(stat.Values["cpu_utilization"], ShouldEqual, 0.0)
+		So(stat.Values["rss"], ShouldEqual, 2048.0)
+		stat = newProcessStat(nil, current, 0)
+		So(stat.Values["major_faults"], ShouldEqual, 0.0)
+	})
+	Convey("Top processes should be sorted by metric and pid", t, func() {
+		stats := []ProcessStat{
+			{PID: "10", Values: map[string]float64{"rss": 1}},
+			{PID: "3", Values: map[string]float64{"rss": 5}},
+			{PID: "2", Values: map[string]float64{"rss": 1}},
+		}
+		top := topProcesses(stats, "rss")
+		So([]string{top[0].PID, top[1].PID, top[2].PID}, ShouldResemble, []string{"3", "2", "10"})
+		So(stats[0].PID, ShouldEqual, "10")
+	})
+	Convey("Process started after previous scan should be compared to its start", t, func() {
+		last := &processScan{uptime: 100, samples: map[string]processSample{"7": {pid: "7", start: 500, cpu: 10}}}
+		sample, elapsed := baseline(last, processSample{pid: "7", start: 500}, 110, 10*time.Second)
+		So(sample.cpu, ShouldEqual, 10)
+		So(elapsed, ShouldEqual, 10*time.Second)
+		sample, elapsed = baseline(last, processSample{pid: "8", start: 10500}, 110, 10*time.Second)
+		So(sample, ShouldResemble, &processSample{pid: "8", start: 10500})
+		So(elapsed, ShouldEqual, 5*time.Second)
+		sample, _ = baseline(last, processSample{pid: "9", start: 9000}, 110, 10*time.Second)
+		So(sample, ShouldBeNil)
+		sample, _ = baseline(nil, processSample{pid: "8", start: 10500}, 110, 0)
+		So(sample, ShouldBeNil)
+	})
+	Convey("Process usage should include reaped children", t, func() {
+		last := processSample{pid: "1", start: 2, cpu: 100, childCPU: 50, readBytes: 10, writeBytes: 20}
+		current := processSample{pid: "1", start: 2, cpu: 110, childCPU: 450, readBytes: 4106, writeBytes: 20}
+		So(newProcessUsage(&last, current), ShouldResemble, processUsage{cpu: 410, readBytes: 4096})
+		So(newProcessUsage(&processSample{pid: "2", start: 10500}, current), ShouldResemble, processUsage{cpu: 560, readBytes: 4106, writeBytes: 20})
+		So(newProcessUsage(nil, current), ShouldResemble, processUsage{})
+	})
+	Convey("Usage accounted to exited processes should be deducted from surviving ancestor", t, func() {
+		last := &processScan{samples: map[string]processSample{
+			"10": {pid: "10", ppid: "1", start: 100, cpu: 100},
+			"20": {pid: "20", ppid: "10", start: 200, cpu: 50, readBytes: 100},
+			"30": {pid: "30", ppid: "20", start: 300, cpu: 30, childCPU: 10},
+		}}
+		samples := map[string]processSample{"10": {pid: "10", ppid: "1", start: 100}}
+		// 20 and 30 were reaped, 10 accounts their whole usage
+		usage := map[string]processUsage{"10": {cpu: 300, readBytes: 150}}
+		deductReaped(last, samples, usage)
+		So(usage["10"], ShouldResemble, processUsage{cpu: 210, readBytes: 50})
+	})
+	Convey("Process started between scans should be charged whole usage", t, func() {
+		u := &Use{ProcPath: "proc", PasswdPath: "rootfs/etc/passwd", ProcessScanInterval: time.Minute}
+		_, err := u.scanProcesses()
+		So(err, ShouldBeNil)
+		// java (1422) started at 98s after boot, after previous scan
+		delete(u.processes.samples, "1422")
+		u.processes.uptime = 90
+		u.processes.timestamp = time.Now().Add(-2 * time.Minute)
+		scan, err := u.scanProcesses()
+		So(err, ShouldBeNil)
+		So(scan.usage["1422"], ShouldResemble, processUsage{cpu: 10000, readBytes: 40960, writeBytes: 1024})
+		So(scan.usage["812"], ShouldResemble, processUsage{})
+		for _, stat := range scan.stats {
+			if stat.PID == "1422" {
+				So(stat.Values["cpu_utilization"], ShouldBeGreaterThan, 0.0)
+			}
+		}
+	})
+	Convey("Stale scan should not be used as baseline", t, func() {
+		u := &Use{ProcPath: "proc", PasswdPath: "rootfs/etc/passwd", ProcessScanInterval: time.Minute}
+		_, err := u.scanProcesses()
+		So(err, ShouldBeNil)
+		u.processes.timestamp = time.Now().Add(-processBaselineMaxAge - time.Minute)
+		scan, err := u.scanProcesses()
+		So(err, ShouldBeNil)
+		So(scan.elapsed, ShouldEqual, 0)
+		So(scan.usage["1422"], ShouldResemble, processUsage{})
+		// scan interval longer than the bound extends it
+		u.ProcessScanInterval = processBaselineMaxAge
+		u.processes.timestamp = time.Now().Add(-processBaselineMaxAge - time.Minute)
+		scan, err = u.scanProcesses()
+		So(err, ShouldBeNil)
+		So(scan.elapsed, ShouldBeGreaterThan, processBaselineMaxAge)
+	})
+	Convey("Uptime should be read from procfs", t, func() {
+		uptime, err := readUptime("proc")
+		So(err, ShouldBeNil)
+		So(uptime, ShouldEqual, 12345.67)
+		_, err = readUptime("/some/proc")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("User names should be read from passwd", t, func() {
+		users, err := readPasswd("rootfs/etc/passwd")
+		So(err, ShouldBeNil)
+		So(users, ShouldResemble, map[string]string{"0": "root", "1": "daemon", "1000": "alice"})
+	})
+	Convey("Processes should be scanned again after scan interval", t, func() {
+		u := &Use{ProcPath: "proc", PasswdPath: "rootfs/etc/passwd", ProcessScanInterval: time.Minute}
+		scan, err := u.scanProcesses()
+		So(err, ShouldBeNil)
+		So(len(scan.stats), ShouldEqual, 4)
+		So(scan.elapsed, ShouldEqual, 0)
+		again, err := u.scanProcesses()
+		So(err, ShouldBeNil)
+		So(again, ShouldEqual, scan)
+		u.processes.timestamp = time.Now().Add(-2 * time.Minute)
+		again, err = u.scanProcesses()
+		So(err, ShouldBeNil)
+		So(again, ShouldNotEqual, scan)
+		So(again.elapsed, ShouldBeGreaterThanOrEqualTo, 2*time.Minute)
+		users := map[string]string{}
+		for _, stat := range again.stats {
+			users[stat.PID] = stat.User
+		}
+		So(users, ShouldResemble, map[string]string{"1": "root", "812": "alice", "1422": "1001", "2048": "root"})
+	})
+}
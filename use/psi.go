@@ -0,0 +1,98 @@
+package use
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+	"github.com/pkg/errors"
+)
+
+var (
+	// pressureResources maps USE resources to files in /proc/pressure
+	pressureResources = map[string]string{
+		"compute": "cpu",
+		"memory":  "memory",
+		"storage": "io",
+	}
+	// pressureResourceLabels keeps order of resources in metric catalog
+	pressureResourceLabels = []string{"compute", "memory", "storage"}
+	pressureFields         = []string{"avg10", "avg60", "avg300", "total"}
+)
+
+// readPressure returns Pressure Stall Information of resource, values are
+// keyed by line and field, e.g. "some" -> "avg10"
+func readPressure(pressurePath string, resource string) (map[string]map[string]float64, error) {
+	filename := filepath.Join(pressurePath, pressureResources[resource])
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil, errors.Errorf("Pressure Stall Information is not available in %s, kernel 4.20+ with PSI enabled is required", pressurePath)
+	}
+	lines, err := readLines(filename)
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]map[string]float64{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		values := map[string]float64{}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return nil, errors.Errorf("Unable to parse pressure field %s of %s", field, filename)
+			}
+			values[kv[0]], err = strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				return nil, errors.Errorf("Unable to parse pressure field %s of %s: %s", field, filename, err.Error())
+			}
+		}
+		ret[fields[0]] = values
+	}
+	return ret, nil
+}
+
+func getPressureMetricTypes(pressurePath string) ([]plugin.Metric, error) {
+	var mts []plugin.Metric
+	for _, resource := range pressureResourceLabels {
+		pressure, err := readPressure(pressurePath, resource)
+		if err != nil {
+			log.Infof("Skipping %s pressure metrics: %s", resource, err.Error())
+			continue
+		}
+		for _, kind := range []string{"some", "full"} {
+			if _, ok := pressure[kind]; !ok {
+				// full line is not reported for cpu by kernels older than 5.13
+				continue
+			}
+			for _, field := range pressureFields {
+				mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", resource, "pressure", kind, field)})
+			}
+		}
+	}
+	return mts, nil
+}
+
+func (u *Use) pressureStat(ns plugin.Namespace) (*plugin.Metric, error) {
+	elements := ns.Strings()
+	if len(elements) != 6 {
+		return nil, errors.Errorf("Unknown pressure namespace %v", ns)
+	}
+	resource, kind, field := elements[2], elements[4], elements[5]
+	pressure, err := readPressure(u.PressurePath, resource)
+	if err != nil {
+		return nil, errors.Errorf("Unable to get %s pressure: %s", resource, err.Error())
+	}
+	metric, ok := pressure[kind][field]
+	if !ok {
+		return nil, errors.Errorf("Unable to find %s %s in %s pressure", kind, field, resource)
+	}
+	return &plugin.Metric{
+		Namespace: ns,
+		Data:      metric,
+	}, nil
+}
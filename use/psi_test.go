@@ -0,0 +1,64 @@
+//
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package use
+
+import (
+	"testing"
+
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPressureUsePlugin(t *testing.T) {
+	Convey("Read pressure should return some and full values", t, func() {
+		pressure, err := readPressure("proc/pressure", "storage")
+		So(err, ShouldBeNil)
+		So(pressure["some"]["avg10"], ShouldEqual, 4.21)
+		So(pressure["some"]["total"], ShouldEqual, 981327543)
+		So(pressure["full"]["avg300"], ShouldEqual, 0.79)
+	})
+	Convey("Read pressure without PSI should return error", t, func() {
+		_, err := readPressure("/some/proc/pressure", "compute")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "not available")
+	})
+	Convey("Pressure metric types should cover all resources", t, func() {
+		mts, err := getPressureMetricTypes("proc/pressure")
+		So(err, ShouldBeNil)
+		So(len(mts), ShouldEqual, 24)
+		So(mts[0].Namespace.String(), ShouldEqual, "/intel/use/compute/pressure/some/avg10")
+	})
+	Convey("Pressure metric types without PSI should be empty", t, func() {
+		mts, err := getPressureMetricTypes("/some/proc/pressure")
+		So(err, ShouldBeNil)
+		So(mts, ShouldBeEmpty)
+	})
+	Convey("Collect pressure metric", t, func() {
+		u := &Use{PressurePath: "proc/pressure"}
+		metric, err := u.pressureStat(plugin.NewNamespace("intel", "use", "memory", "pressure", "full", "avg60"))
+		So(err, ShouldBeNil)
+		So(metric.Data, ShouldEqual, 0.08)
+		_, err = u.pressureStat(plugin.NewNamespace("intel", "use", "memory", "pressure", "full", "avg5"))
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,142 @@
+package use
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+	"github.com/pkg/errors"
+)
+
+// unitSuffixes are types of systemd units which own a cgroup
+var unitSuffixes = []string{".service", ".scope", ".socket", ".mount", ".swap"}
+
+// isUnit checks if cgroup directory belongs to systemd unit
+func isUnit(name string) bool {
+	for _, suffix := range unitSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// listUnits returns cgroup paths of systemd units keyed by unit name,
+// units are searched in slices only, cgroups created inside of units are skipped
+func (c *cgroupFS) listUnits() (map[string]string, error) {
+	base, err := filepath.EvalSymlinks(c.dir("cpu", ""))
+	if err != nil {
+		return nil, errors.Errorf("Unable to find cgroup hierarchy in %s: %s", c.root, err.Error())
+	}
+	units := map[string]string{}
+	err = filepath.Walk(base, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == base {
+				return err
+			}
+			log.Debugf("Skipping cgroup %s: %s", p, err.Error())
+			return nil
+		}
+		if !info.IsDir() || p == base {
+			return nil
+		}
+		if strings.HasSuffix(info.Name(), ".slice") {
+			return nil
+		}
+		if isUnit(info.Name()) {
+			rel, err := filepath.Rel(base, p)
+			if err != nil {
+				return err
+			}
+			units[info.Name()] = filepath.ToSlash(rel)
+		}
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	return units, nil
+}
+
+// unitTags returns tags identifying systemd unit and slice it belongs to
+func unitTags(unit string, cgroup string) map[string]string {
+	slice := path.Base(path.Dir(cgroup))
+	if slice == "." {
+		// units placed directly in root cgroup belong to root slice
+		slice = "-.slice"
+	}
+	return map[string]string{"unit": unit, "slice": slice}
+}
+
+// unitCgroup returns cgroup of systemd unit, units are looked up again
+// when unit is not known, e.g. it was started after previous lookup
+func (u *Use) unitCgroup(unit string) (string, error) {
+	u.mutex.Lock()
+	cgroup, ok := u.units[unit]
+	u.mutex.Unlock()
+	if ok {
+		return cgroup, nil
+	}
+
+	units, err := newCgroupFS(u.CgroupPath).listUnits()
+	if err != nil {
+		return "", err
+	}
+	u.mutex.Lock()
+	u.units = units
+	u.mutex.Unlock()
+	if cgroup, ok = units[unit]; !ok {
+		return "", errors.Errorf("Unable to find cgroup of unit %s", unit)
+	}
+	return cgroup, nil
+}
+
+// getUnitMetricTypes returns metrics of systemd units, metrics of resource
+// are published only for units with statistics of its controller
+func getUnitMetricTypes(cgroupPath string, filter *deviceFilter) []plugin.Metric {
+	var mts []plugin.Metric
+	cgroups := newCgroupFS(cgroupPath)
+	units, err := cgroups.listUnits()
+	if err != nil {
+		log.Infof("Skipping systemd unit metrics: %s", err.Error())
+		return mts
+	}
+	names := []string{}
+	for unit := range units {
+		names = append(names, unit)
+	}
+	sort.Strings(names)
+	for _, unit := range filter.filter(names) {
+		for _, m := range cgroupMetrics {
+			if !cgroups.hasResource(units[unit], m.resource) {
+				continue
+			}
+			mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "systemd", unit, m.resource, m.name)})
+		}
+	}
+	return mts
+}
+
+func (u *Use) unitStat(ns plugin.Namespace) (*plugin.Metric, error) {
+	if len(ns) != 6 || !isCgroupMetric(ns.Strings()[4], ns.Strings()[5]) {
+		return nil, errors.Errorf("Unknown systemd unit stat namespace %v", ns)
+	}
+	unit := ns.Strings()[3]
+	cgroup, err := u.unitCgroup(unit)
+	if err != nil {
+		return nil, err
+	}
+	metric, err := u.readCgroupMetric(ns, cgroup, ns.Strings()[4], ns.Strings()[5])
+	if err != nil {
+		return nil, err
+	}
+	return &plugin.Metric{
+		Namespace: ns,
+		Data:      metric,
+		Tags:      unitTags(unit, cgroup),
+	}, nil
+}
@@ -0,0 +1,70 @@
+//
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package use
+
+import (
+	"testing"
+
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSystemd(t *testing.T) {
+	Convey("Systemd units should be found in slices", t, func() {
+		units, err := newCgroupFS("sys/fs/cgroup").listUnits()
+		So(err, ShouldBeNil)
+		So(units, ShouldResemble, map[string]string{
+			"init.scope":        "init.scope",
+			"docker-0123.scope": "system.slice/docker-0123.scope",
+			"sshd.service":      "system.slice/sshd.service",
+			"user@1000.service": "user.slice/user-1000.slice/user@1000.service",
+		})
+		units, err = newCgroupFS("cgroupv1").listUnits()
+		So(err, ShouldBeNil)
+		So(units, ShouldBeEmpty)
+		_, err = newCgroupFS("/some/cgroup").listUnits()
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Unit tags should contain unit and slice", t, func() {
+		So(unitTags("sshd.service", "system.slice/sshd.service"), ShouldResemble, map[string]string{"unit": "sshd.service", "slice": "system.slice"})
+		So(unitTags("init.scope", "init.scope"), ShouldResemble, map[string]string{"unit": "init.scope", "slice": "-.slice"})
+	})
+	Convey("Unit metric types should be published for filtered units", t, func() {
+		mts := getUnitMetricTypes("sys/fs/cgroup", nil)
+		// init.scope has only compute statistics, user@1000.service has none
+		So(len(mts), ShouldEqual, 2*len(cgroupMetrics)+3)
+		So(mts[0].Namespace.String(), ShouldEqual, "/intel/use/systemd/docker-0123.scope/compute/utilization")
+		filter, err := newDeviceFilter(plugin.Config{"unit_include": `\.service$`}, "unit", "sys/fs/cgroup")
+		So(err, ShouldBeNil)
+		So(len(getUnitMetricTypes("sys/fs/cgroup", filter)), ShouldEqual, len(cgroupMetrics))
+	})
+	Convey("Unit cgroup should be looked up when unit is not known", t, func() {
+		u := &Use{CgroupPath: "sys/fs/cgroup"}
+		cgroup, err := u.unitCgroup("sshd.service")
+		So(err, ShouldBeNil)
+		So(cgroup, ShouldEqual, "system.slice/sshd.service")
+		So(u.units, ShouldContainKey, "init.scope")
+		_, err = u.unitCgroup("cron.service")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,149 @@
+package use
+
+import (
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+	"github.com/pkg/errors"
+)
+
+// tagProviders are optional sources of host tags which can be enabled
+// with tag_providers config, they are resolved together with host tags
+var tagProviders = map[string]func(procPath string, sysPath string) map[string]string{
+	"kernel_version": kernelVersionTags,
+	"boot_id":        bootIDTags,
+	"cpu_model":      cpuModelTags,
+	"dmi":            dmiSerialTags,
+}
+
+// tagConfig holds tags added to and removed from every metric
+type tagConfig struct {
+	static    map[string]string
+	drop      map[string]bool
+	providers []string
+}
+
+// newTagConfig creates tag configuration from tags, tags_drop and
+// tag_providers entries of plugin config
+func newTagConfig(cfg plugin.Config) (*tagConfig, error) {
+	c := &tagConfig{static: map[string]string{}, drop: map[string]bool{}}
+
+	static, err := cfg.GetString("tags")
+	if err == nil {
+		for _, pair := range splitList(static) {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+				return nil, errors.Errorf("Invalid tag %s, expected key=value", pair)
+			}
+			c.static[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	drop, err := cfg.GetString("tags_drop")
+	if err == nil {
+		for _, key := range splitList(drop) {
+			c.drop[key] = true
+		}
+	}
+
+	providers, err := cfg.GetString("tag_providers")
+	if err == nil {
+		for _, name := range splitList(providers) {
+			if _, ok := tagProviders[name]; !ok {
+				return nil, errors.Errorf("Unknown tag provider %s", name)
+			}
+			c.providers = append(c.providers, name)
+		}
+	}
+	return c, nil
+}
+
+// apply adds static tags to and removes dropped tags from tags, static tags
+// take precedence over collected ones
+func (c *tagConfig) apply(tags map[string]string) map[string]string {
+	if c == nil {
+		return tags
+	}
+	for k, v := range c.static {
+		tags[k] = v
+	}
+	for k := range c.drop {
+		delete(tags, k)
+	}
+	return tags
+}
+
+// metricTags returns host tags merged with tags specific for a metric,
+// adjusted by tag configuration
+func (u *Use) metricTags(extra map[string]string) map[string]string {
+	tags := u.hostTags()
+	for k, v := range extra {
+		tags[k] = v
+	}
+	return u.tagConfig.apply(tags)
+}
+
+// splitList splits comma separated config value skipping empty entries
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func kernelVersionTags(procPath string, sysPath string) map[string]string {
+	release, err := readString(filepath.Join(procPath, "sys", "kernel", "osrelease"))
+	if err != nil {
+		log.Warnf("Unable to get kernel version: %s", err.Error())
+		return nil
+	}
+	return map[string]string{"kernel_version": release}
+}
+
+func bootIDTags(procPath string, sysPath string) map[string]string {
+	bootID, err := readString(filepath.Join(procPath, "sys", "kernel", "random", "boot_id"))
+	if err != nil {
+		log.Warnf("Unable to get boot id: %s", err.Error())
+		return nil
+	}
+	return map[string]string{"boot_id": bootID}
+}
+
+func cpuModelTags(procPath string, sysPath string) map[string]string {
+	lines, err := readLines(filepath.Join(procPath, "cpuinfo"))
+	if err != nil {
+		log.Warnf("Unable to get cpu model: %s", err.Error())
+		return nil
+	}
+	for _, line := range lines {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "model name" {
+			return map[string]string{"cpu_model": strings.TrimSpace(parts[1])}
+		}
+	}
+	return nil
+}
+
+// dmiSerialTags returns DMI product version and serial number, the serial
+// is readable only when plugin runs with root privileges
+func dmiSerialTags(procPath string, sysPath string) map[string]string {
+	tags := map[string]string{}
+	dmiPath := filepath.Join(sysPath, "class", "dmi", "id")
+	for tag, file := range map[string]string{"system_version": "product_version", "system_serial": "product_serial"} {
+		value, err := readString(filepath.Join(dmiPath, file))
+		if err != nil {
+			log.Warnf("Unable to get %s: %s", tag, err.Error())
+			continue
+		}
+		if value != "" {
+			tags[tag] = value
+		}
+	}
+	return tags
+}
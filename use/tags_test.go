@@ -0,0 +1,76 @@
+//
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package use
+
+import (
+	"testing"
+
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTags(t *testing.T) {
+	Convey("Tag config should be created from plugin config", t, func() {
+		c, err := newTagConfig(plugin.Config{
+			"tags":          "cluster=east, rack=r12",
+			"tags_drop":     "platform_family,",
+			"tag_providers": "kernel_version,cpu_model",
+		})
+		So(err, ShouldBeNil)
+		So(c.static, ShouldResemble, map[string]string{"cluster": "east", "rack": "r12"})
+		So(c.drop, ShouldResemble, map[string]bool{"platform_family": true})
+		So(c.providers, ShouldResemble, []string{"kernel_version", "cpu_model"})
+	})
+	Convey("Invalid tag config should return error", t, func() {
+		_, err := newTagConfig(plugin.Config{"tags": "cluster"})
+		So(err, ShouldNotBeNil)
+		_, err = newTagConfig(plugin.Config{"tag_providers": "rack"})
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Static tags should be added and dropped tags removed", t, func() {
+		c := &tagConfig{static: map[string]string{"cluster": "east", "os": "custom"}, drop: map[string]bool{"hostname": true}}
+		tags := c.apply(map[string]string{"hostname": "node-01", "os": "linux"})
+		So(tags, ShouldResemble, map[string]string{"cluster": "east", "os": "custom"})
+		var none *tagConfig
+		So(none.apply(map[string]string{"os": "linux"}), ShouldResemble, map[string]string{"os": "linux"})
+	})
+	Convey("Tag providers should read host properties", t, func() {
+		So(kernelVersionTags("proc", "sys"), ShouldResemble, map[string]string{"kernel_version": "4.4.0-87-generic"})
+		So(bootIDTags("proc", "sys"), ShouldResemble, map[string]string{"boot_id": "6f2c3a5e-1b1e-4bb8-9d1c-6c2f6f1f7b2a"})
+		So(cpuModelTags("proc", "sys"), ShouldResemble, map[string]string{"cpu_model": "Intel(R) Xeon(R) CPU E5-2699 v4 @ 2.20GHz"})
+		So(dmiSerialTags("proc", "sys"), ShouldResemble, map[string]string{"system_version": "pc-i440fx-2.8", "system_serial": "0123456789"})
+		So(kernelVersionTags("/some/proc", "/some/sys"), ShouldBeEmpty)
+		So(dmiSerialTags("/some/proc", "/some/sys"), ShouldBeEmpty)
+	})
+	Convey("Metric tags should include providers and apply tag config", t, func() {
+		c, err := newTagConfig(plugin.Config{"tags": "rack=r12", "tags_drop": "platform_family", "tag_providers": "boot_id"})
+		So(err, ShouldBeNil)
+		u := &Use{ProcPath: "proc", SysPath: "sys", RootPath: "rootfs", tagConfig: c}
+		tags := u.metricTags(map[string]string{"device": "sda"})
+		So(tags["rack"], ShouldEqual, "r12")
+		So(tags["device"], ShouldEqual, "sda")
+		So(tags["boot_id"], ShouldEqual, "6f2c3a5e-1b1e-4bb8-9d1c-6c2f6f1f7b2a")
+		So(tags["hostname"], ShouldEqual, "node-01")
+		So(tags, ShouldNotContainKey, "platform_family")
+	})
+}
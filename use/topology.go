@@ -0,0 +1,158 @@
+package use
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+	"github.com/pkg/errors"
+)
+
+// topologyMetrics are names of metrics describing CPU topology of the host
+var topologyMetrics = []string{"cpus_online", "sockets", "cores", "threads_per_core"}
+
+// CPUTopology describes online CPUs of the measured host
+type CPUTopology struct {
+	Online  int
+	Sockets int
+	Cores   int
+}
+
+// ThreadsPerCore returns number of hardware threads per physical core
+func (c *CPUTopology) ThreadsPerCore() float64 {
+	if c.Cores <= 0 {
+		return 0.0
+	}
+	return float64(c.Online) / float64(c.Cores)
+}
+
+// onlineCPUs returns number of online CPUs of the measured host
+func onlineCPUs(cpuStatPath string, cpuSysPath string) (int, error) {
+	cpus, err := listOnlineCPUs(cpuStatPath, cpuSysPath)
+	if err != nil {
+		return 0, err
+	}
+	if len(cpus) == 0 {
+		return 0, errors.Errorf("Unable to find online CPUs")
+	}
+	return len(cpus), nil
+}
+
+// listOnlineCPUs returns ids of online CPUs from sysfs online list, when it
+// is not available CPUs reported by /proc/stat are used
+func listOnlineCPUs(cpuStatPath string, cpuSysPath string) ([]string, error) {
+	lines, err := readLines(filepath.Join(cpuSysPath, "online"))
+	if err == nil {
+		cpus, err := parseCPUList(lines[0])
+		if err == nil {
+			return cpus, nil
+		}
+		log.Warnf("Unable to parse online CPUs of %s: %s", cpuSysPath, err.Error())
+	}
+	stats, err := readCPUStats(cpuStatPath)
+	if err != nil {
+		return nil, err
+	}
+	return listCPUs(stats), nil
+}
+
+// parseCPUList parses kernel CPU list format, e.g. "0-3,6,8-9"
+func parseCPUList(list string) ([]string, error) {
+	cpus := []string{}
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return cpus, nil
+	}
+	for _, part := range strings.Split(list, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, errors.Errorf("Invalid CPU list %s", list)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, errors.Errorf("Invalid CPU list %s", list)
+			}
+		}
+		for id := first; id <= last; id++ {
+			cpus = append(cpus, strconv.Itoa(id))
+		}
+	}
+	return cpus, nil
+}
+
+// readCPUTopology counts online CPUs, sockets and cores of the measured host,
+// sockets and cores are left 0 when sysfs topology is not available
+func readCPUTopology(cpuStatPath string, cpuSysPath string) (*CPUTopology, error) {
+	cpus, err := listOnlineCPUs(cpuStatPath, cpuSysPath)
+	if err != nil {
+		return nil, err
+	}
+	topology := &CPUTopology{Online: len(cpus)}
+	sockets := map[int64]bool{}
+	cores := map[[2]int64]bool{}
+	for _, id := range cpus {
+		dir := filepath.Join(cpuSysPath, "cpu"+id, "topology")
+		socket, err := readInt(filepath.Join(dir, "physical_package_id"))
+		if err != nil {
+			return topology, nil
+		}
+		core, err := readInt(filepath.Join(dir, "core_id"))
+		if err != nil {
+			return topology, nil
+		}
+		sockets[socket] = true
+		cores[[2]int64{socket, core}] = true
+	}
+	topology.Sockets = len(sockets)
+	topology.Cores = len(cores)
+	return topology, nil
+}
+
+func getTopologyMetricTypes(cpuStatPath string, cpuSysPath string) []plugin.Metric {
+	var mts []plugin.Metric
+	topology, err := readCPUTopology(cpuStatPath, cpuSysPath)
+	if err != nil {
+		log.Infof("Skipping cpu topology metrics: %s", err.Error())
+		return mts
+	}
+	for _, name := range topologyMetrics {
+		if name != "cpus_online" && topology.Cores == 0 {
+			continue
+		}
+		mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "compute", "topology", name)})
+	}
+	return mts
+}
+
+func (u *Use) topologyStat(ns plugin.Namespace) (*plugin.Metric, error) {
+	name := ns.Strings()[len(ns)-1]
+	topology, err := readCPUTopology(u.CpuStatPath, u.CpuSysPath)
+	if err != nil {
+		return nil, errors.Errorf("Unable to get cpu topology: %s", err.Error())
+	}
+	if name != "cpus_online" && topology.Cores == 0 {
+		return nil, errors.Errorf("Unable to get cpu topology %s: %s is not available", name, u.CpuSysPath)
+	}
+	var metric float64
+	switch name {
+	case "cpus_online":
+		metric = float64(topology.Online)
+	case "sockets":
+		metric = float64(topology.Sockets)
+	case "cores":
+		metric = float64(topology.Cores)
+	case "threads_per_core":
+		metric = topology.ThreadsPerCore()
+	default:
+		return nil, errors.Errorf("Unknown cpu topology namespace %v", ns)
+	}
+	return &plugin.Metric{
+		Namespace: ns,
+		Data:      metric,
+	}, nil
+}
@@ -0,0 +1,58 @@
+//
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package use
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTopology(t *testing.T) {
+	Convey("CPU list should be expanded", t, func() {
+		cpus, err := parseCPUList("0-2,5,7-8\n")
+		So(err, ShouldBeNil)
+		So(cpus, ShouldResemble, []string{"0", "1", "2", "5", "7", "8"})
+		cpus, err = parseCPUList("")
+		So(err, ShouldBeNil)
+		So(cpus, ShouldBeEmpty)
+		_, err = parseCPUList("3-1")
+		So(err, ShouldNotBeNil)
+		_, err = parseCPUList("a")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Topology should be read from sysfs", t, func() {
+		topology, err := readCPUTopology("proc/stat", "sys/devices/system/cpu")
+		So(err, ShouldBeNil)
+		So(topology, ShouldResemble, &CPUTopology{Online: 8, Sockets: 1, Cores: 4})
+		So(topology.ThreadsPerCore(), ShouldEqual, 2.0)
+	})
+	Convey("Online CPUs should fall back to /proc/stat without sysfs", t, func() {
+		count, err := onlineCPUs("proc/stat", "/some/sys/devices/system/cpu")
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 8)
+		topology, err := readCPUTopology("proc/stat", "/some/sys/devices/system/cpu")
+		So(err, ShouldBeNil)
+		So(topology, ShouldResemble, &CPUTopology{Online: 8})
+		So(getTopologyMetricTypes("proc/stat", "/some/sys/devices/system/cpu"), ShouldHaveLength, 1)
+	})
+}
@@ -23,6 +23,8 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -35,8 +37,6 @@ const (
 	// Version of plugin
 	version = 1
 
-	waitTime = 10 * time.Millisecond
-
 	PluginName = "use"
 
 	PluginVersion = 1
@@ -46,30 +46,140 @@ var (
 	metricLabels = []string{
 		"utilization",
 		"saturation",
+		"errors",
 	}
 	cpure  = regexp.MustCompile(`^/intel/use/compute/.*`)
 	storre = regexp.MustCompile(`^/intel/use/storage/.*`)
 	memre  = regexp.MustCompile(`^/intel/use/memory/.*`)
+	netre  = regexp.MustCompile(`^/intel/use/network/.*`)
+	psire  = regexp.MustCompile(`^/intel/use/(compute|memory|storage)/pressure/.*`)
+	cgre   = regexp.MustCompile(`^/intel/use/cgroup/.*`)
+	unitre = regexp.MustCompile(`^/intel/use/systemd/.*`)
+	procre = regexp.MustCompile(`^/intel/use/process/.*`)
+	userre = regexp.MustCompile(`^/intel/use/user/.*`)
+	collre = regexp.MustCompile(`^/intel/use/collector/failed_metrics$`)
 )
 
 // Use contains values of previous measurments
 type Use struct {
-	Host         string
-	initialized  bool
-	ProcPath     string
-	DiskStatPath string
-	CpuStatPath  string
-	LoadAvgPath  string
-	MemInfoPath  string
-	VmStatPath   string
+	Host           string
+	initialized    bool
+	ProcPath       string
+	DiskStatPath   string
+	CpuStatPath    string
+	SchedStatPath  string
+	LoadAvgPath    string
+	MemInfoPath    string
+	VmStatPath     string
+	NetDevPath     string
+	InterruptsPath string
+	PressurePath   string
+	SysPath        string
+	CpuSysPath     string
+	EdacPath       string
+	RootPath       string
+	CgroupPath     string
+	TagsRefresh    time.Duration
+	PasswdPath     string
+
+	ProcessScanInterval time.Duration
+
+	// KubeletPodsPath and ContainerStatePath are optional sources of
+	// kubernetes pod and container names
+	KubeletPodsPath    string
+	ContainerStatePath string
+
+	storageFilter *deviceFilter
+	networkFilter *deviceFilter
+	tagConfig     *tagConfig
+	cgroupFilter  *deviceFilter
+	cgroupDepth   int
+	unitFilter    *deviceFilter
+
+	processScanner bool
+	processTopN    int
+
+	mutex       sync.Mutex
+	snapshots   map[string]snapshot
+	tags        map[string]string
+	tagsUpdated time.Time
+	// deviceTags caches metadata of storage and network devices and
+	// kubernetes pods and containers
+	deviceTags map[string]cachedTags
+	// warmUps contains namespaces which had no previous snapshot during
+	// current collection
+	warmUps map[string]bool
+	// units maps systemd units to their cgroups
+	units map[string]string
+
+	// processMutex serializes process scans, which are slow
+	processMutex sync.Mutex
+	processes    *processScan
+	// reportedScans contains latest scan reported by user metrics
+	reportedScans map[string]time.Time
+}
+
+// snapshot contains counters read during previous collection of a metric
+type snapshot struct {
+	counters  map[string]int64
+	timestamp time.Time
 }
 
 // NewUseCollector returns Use struct
 func NewUseCollector() *Use {
-	return &Use{}
+	return &Use{snapshots: map[string]snapshot{}}
 }
 
-func (u *Use) init(cfg plugin.Config) {
+// swapSnapshot stores counters read for given namespace and returns snapshot
+// taken during previous collection of the same namespace. On the first
+// collection there is no previous snapshot, so ok is false and the namespace
+// is marked as warming up, its value is not published.
+func (u *Use) swapSnapshot(ns plugin.Namespace, counters map[string]int64) (last snapshot, elapsed time.Duration, ok bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.snapshots == nil {
+		u.snapshots = map[string]snapshot{}
+	}
+	now := time.Now()
+	last, ok = u.snapshots[ns.String()]
+	u.snapshots[ns.String()] = snapshot{counters: counters, timestamp: now}
+	elapsed = now.Sub(last.timestamp)
+	if !ok || elapsed <= 0 {
+		log.Debugf("No previous snapshot for %s, skipping warm-up value", ns.String())
+		if u.warmUps == nil {
+			u.warmUps = map[string]bool{}
+		}
+		u.warmUps[ns.String()] = true
+		return last, 0, false
+	}
+	return last, elapsed, true
+}
+
+// markWarmUp marks namespace as warming up when its value is computed
+// without a baseline, e.g. from the first scan of processes
+func (u *Use) markWarmUp(ns plugin.Namespace) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.warmUps == nil {
+		u.warmUps = map[string]bool{}
+	}
+	u.warmUps[ns.String()] = true
+}
+
+// warmingUp reports and clears warm-up mark of namespace set by swapSnapshot
+// or markWarmUp
+func (u *Use) warmingUp(ns plugin.Namespace) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	warmUp := u.warmUps[ns.String()]
+	delete(u.warmUps, ns.String())
+	return warmUp
+}
+
+func (u *Use) init(cfg plugin.Config) error {
 	f, err := os.OpenFile("/tmp/intel-collector-use", os.O_WRONLY|os.O_CREATE, 0666)
 	if err == nil {
 		log.SetOutput(f)
@@ -83,68 +193,231 @@ func (u *Use) init(cfg plugin.Config) {
 	u.ProcPath = procPath
 	u.DiskStatPath = filepath.Join(procPath, "diskstats")
 	u.CpuStatPath = filepath.Join(procPath, "stat")
+	u.SchedStatPath = filepath.Join(procPath, "schedstat")
 	u.LoadAvgPath = filepath.Join(procPath, "loadavg")
 	u.MemInfoPath = filepath.Join(procPath, "meminfo")
 	u.VmStatPath = filepath.Join(procPath, "vmstat")
+	u.NetDevPath = filepath.Join(procPath, "net", "dev")
+	u.InterruptsPath = filepath.Join(procPath, "interrupts")
+	u.PressurePath = filepath.Join(procPath, "pressure")
+
+	sysPath, err := cfg.GetString("sys_path")
+	if err != nil {
+		sysPath = "/sys_host"
+	}
+	u.SysPath = sysPath
+	u.CpuSysPath = filepath.Join(sysPath, "devices", "system", "cpu")
+	u.EdacPath = filepath.Join(sysPath, "devices", "system", "edac", "mc")
+
+	rootPath, err := cfg.GetString("root_path")
+	if err != nil {
+		rootPath = "/rootfs_host"
+	}
+	u.RootPath = rootPath
+	passwdPath, err := cfg.GetString("passwd_path")
+	if err != nil || passwdPath == "" {
+		passwdPath = filepath.Join("etc", "passwd")
+	}
+	// passwd file is always read from host root
+	u.PasswdPath = filepath.Join(rootPath, passwdPath)
+
+	refresh, err := cfg.GetString("host_tags_refresh")
+	if err != nil {
+		refresh = "1h"
+	}
+	u.TagsRefresh, err = time.ParseDuration(refresh)
+	if err != nil {
+		return errors.New("Invalid host_tags_refresh " + refresh + ": " + err.Error())
+	}
+
+	cgroupPath, err := cfg.GetString("cgroup_path")
+	if err != nil || cgroupPath == "" {
+		cgroupPath = filepath.Join(sysPath, "fs", "cgroup")
+	}
+	u.CgroupPath = cgroupPath
+	depth, err := cfg.GetInt("cgroup_max_depth")
+	if err != nil {
+		depth = 4
+	}
+	u.cgroupDepth = int(depth)
+	u.cgroupFilter, err = newDeviceFilter(cfg, "cgroup", cgroupPath)
+	if err != nil {
+		return err
+	}
+	// cgroups are not backed by hardware
+	u.cgroupFilter.physicalOnly = false
+	u.unitFilter, err = newDeviceFilter(cfg, "unit", cgroupPath)
+	if err != nil {
+		return err
+	}
+	u.unitFilter.physicalOnly = false
+
+	u.processScanner, _ = cfg.GetBool("process_scanner")
+	topN, err := cfg.GetInt("process_top_n")
+	if err != nil {
+		topN = 5
+	}
+	u.processTopN = int(topN)
+	scanInterval, err := cfg.GetString("process_scan_interval")
+	if err != nil {
+		scanInterval = "5s"
+	}
+	u.ProcessScanInterval, err = time.ParseDuration(scanInterval)
+	if err != nil {
+		return errors.New("Invalid process_scan_interval " + scanInterval + ": " + err.Error())
+	}
+
+	u.KubeletPodsPath, _ = cfg.GetString("kubelet_pods_path")
+	u.ContainerStatePath, _ = cfg.GetString("container_state_path")
+
+	u.tagConfig, err = newTagConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	u.storageFilter, err = newDeviceFilter(cfg, "storage", filepath.Join(sysPath, "block"))
+	if err != nil {
+		return err
+	}
+	u.networkFilter, err = newDeviceFilter(cfg, "network", filepath.Join(sysPath, "class", "net"))
+	if err != nil {
+		return err
+	}
 	u.initialized = true
+	return nil
 }
 
 // CollectMetrics returns Use metrics
 func (u *Use) CollectMetrics(mts []plugin.Metric) ([]plugin.Metric, error) {
 	cfg := mts[0].Config
 	if !u.initialized {
-		u.init(cfg)
-	}
-
-	metrics := make([]plugin.Metric, len(mts))
-	for i, p := range mts {
-		ns := p.Namespace.String()
-		switch {
-		case cpure.MatchString(ns):
-			metric, err := u.computeStat(p.Namespace)
-			if err != nil {
-				return nil, errors.New("Unable to get compute stat: " + err.Error())
-			}
-			metrics[i] = *metric
-
-		case storre.MatchString(ns):
-			metric, err := u.diskStat(p.Namespace)
-			if err != nil {
-				return nil, errors.New("Unable to get disk stat: " + err.Error())
-			}
-			metrics[i] = *metric
-		case memre.MatchString(ns):
-			metric, err := memStat(p.Namespace, u.VmStatPath, u.MemInfoPath)
-			if err != nil {
-				return nil, errors.New("Unable to get mem stat: " + err.Error())
-			}
-			metrics[i] = *metric
+		if err := u.init(cfg); err != nil {
+			return nil, errors.New("Unable to initialize plugin: " + err.Error())
 		}
-		tags, err := hostTags()
+	}
 
-		if err == nil {
-			metrics[i].Tags = tags
+	metrics := []plugin.Metric{}
+	selfMetrics := []plugin.Metric{}
+	collected, failed := 0, 0
+	for _, p := range mts {
+		if collre.MatchString(p.Namespace.String()) {
+			selfMetrics = append(selfMetrics, p)
+			continue
 		}
-		metrics[i].Timestamp = time.Now()
-
+		metric, err := u.collectMetric(p.Namespace)
+		warmUp := u.warmingUp(p.Namespace)
+		if err != nil {
+			// a single failing namespace, e.g. removed device, should not
+			// prevent publishing of other metrics
+			log.Warnf("Unable to collect %s: %s", p.Namespace.String(), err.Error())
+			failed++
+			continue
+		}
+		collected++
+		if warmUp {
+			// delta of the first collection is unknown, publishing 0 would
+			// look like an idle resource
+			continue
+		}
+		metric.Tags = u.metricTags(metric.Tags)
+		metric.Timestamp = time.Now()
+		metrics = append(metrics, *metric)
+	}
+	if failed > 0 && collected == 0 {
+		return nil, errors.New("Unable to collect any of " + strconv.Itoa(len(mts)) + " requested metrics, " + strconv.Itoa(failed) + " failed, see plugin log for details")
+	}
+	for _, p := range selfMetrics {
+		metrics = append(metrics, plugin.Metric{
+			Namespace: p.Namespace,
+			Data:      float64(failed),
+			Tags:      u.metricTags(nil),
+			Timestamp: time.Now(),
+		})
 	}
 	return metrics, nil
 }
 
+// collectMetric returns metric of namespace with resource specific tags
+func (u *Use) collectMetric(ns plugin.Namespace) (*plugin.Metric, error) {
+	switch {
+	case cgre.MatchString(ns.String()):
+		metric, err := u.cgroupStat(ns)
+		if err != nil {
+			return nil, errors.New("Unable to get cgroup stat: " + err.Error())
+		}
+		return metric, nil
+	case unitre.MatchString(ns.String()):
+		metric, err := u.unitStat(ns)
+		if err != nil {
+			return nil, errors.New("Unable to get systemd unit stat: " + err.Error())
+		}
+		return metric, nil
+	case procre.MatchString(ns.String()):
+		metric, err := u.processStat(ns)
+		if err != nil {
+			return nil, errors.New("Unable to get process stat: " + err.Error())
+		}
+		return metric, nil
+	case userre.MatchString(ns.String()):
+		metric, err := u.userStat(ns)
+		if err != nil {
+			return nil, errors.New("Unable to get user stat: " + err.Error())
+		}
+		return metric, nil
+	case psire.MatchString(ns.String()):
+		metric, err := u.pressureStat(ns)
+		if err != nil {
+			return nil, errors.New("Unable to get pressure stat: " + err.Error())
+		}
+		return metric, nil
+	case cpure.MatchString(ns.String()):
+		metric, err := u.computeStat(ns)
+		if err != nil {
+			return nil, errors.New("Unable to get compute stat: " + err.Error())
+		}
+		return metric, nil
+	case storre.MatchString(ns.String()):
+		metric, err := u.diskStat(ns)
+		if err != nil {
+			return nil, errors.New("Unable to get disk stat: " + err.Error())
+		}
+		disk := ns.Strings()[3]
+		metric.Tags = u.cachedTags("storage/"+disk, func() map[string]string { return readDiskTags(disk, u.SysPath) })
+		return metric, nil
+	case memre.MatchString(ns.String()):
+		metric, err := u.memStat(ns)
+		if err != nil {
+			return nil, errors.New("Unable to get mem stat: " + err.Error())
+		}
+		return metric, nil
+	case netre.MatchString(ns.String()):
+		metric, err := u.netStat(ns)
+		if err != nil {
+			return nil, errors.New("Unable to get network stat: " + err.Error())
+		}
+		iface := ns.Strings()[3]
+		metric.Tags = u.cachedTags("network/"+iface, func() map[string]string { return readNetTags(iface, u.SysPath) })
+		return metric, nil
+	}
+	return nil, errors.New("Unknown namespace " + ns.String())
+}
+
 // GetMetricTypes returns the metric types exposed by use plugin
 func (u *Use) GetMetricTypes(cfg plugin.Config) ([]plugin.Metric, error) {
 	if !u.initialized {
-		u.init(cfg)
+		if err := u.init(cfg); err != nil {
+			return nil, errors.New("Unable to initialize plugin: " + err.Error())
+		}
 	}
 
 	mts := []plugin.Metric{}
 
-	cpu, err := getCPUMetricTypes()
+	cpu, err := getCPUMetricTypes(u.CpuStatPath, u.SchedStatPath, u.CpuSysPath)
 	if err != nil {
 		return nil, errors.New("Unable to get cpu metric types: " + err.Error())
 	}
 	mts = append(mts, cpu...)
-	disk, err := getDiskMetricTypes()
+	disk, err := getDiskMetricTypes(u.SysPath, u.DiskStatPath, u.storageFilter)
 	if err != nil {
 		return nil, errors.New("Unable to get disk metric types: " + err.Error())
 	}
@@ -154,6 +427,23 @@ func (u *Use) GetMetricTypes(cfg plugin.Config) ([]plugin.Metric, error) {
 		return nil, errors.New("Unable to get mem metric types: " + err.Error())
 	}
 	mts = append(mts, mem...)
+	net, err := getNetMetricTypes(u.NetDevPath, u.SysPath, u.networkFilter)
+	if err != nil {
+		return nil, errors.New("Unable to get network metric types: " + err.Error())
+	}
+	mts = append(mts, net...)
+	pressure, err := getPressureMetricTypes(u.PressurePath)
+	if err != nil {
+		return nil, errors.New("Unable to get pressure metric types: " + err.Error())
+	}
+	mts = append(mts, pressure...)
+	mts = append(mts, getCgroupMetricTypes(u.CgroupPath, u.cgroupDepth, u.cgroupFilter)...)
+	mts = append(mts, getUnitMetricTypes(u.CgroupPath, u.unitFilter)...)
+	if u.processScanner {
+		mts = append(mts, getProcessMetricTypes(u.processTopN)...)
+		mts = append(mts, u.getUserMetricTypes()...)
+	}
+	mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "collector", "failed_metrics")})
 
 	return mts, nil
 }
@@ -162,5 +452,28 @@ func (u *Use) GetMetricTypes(cfg plugin.Config) ([]plugin.Metric, error) {
 func (u *Use) GetConfigPolicy() (plugin.ConfigPolicy, error) {
 	policy := plugin.NewConfigPolicy()
 	policy.AddNewStringRule([]string{"intel", "use"}, "proc_path", false, plugin.SetDefaultString("/proc_host"))
+	policy.AddNewStringRule([]string{"intel", "use"}, "sys_path", false, plugin.SetDefaultString("/sys_host"))
+	policy.AddNewStringRule([]string{"intel", "use"}, "root_path", false, plugin.SetDefaultString("/rootfs_host"))
+	policy.AddNewStringRule([]string{"intel", "use"}, "host_tags_refresh", false, plugin.SetDefaultString("1h"))
+	policy.AddNewStringRule([]string{"intel", "use"}, "cgroup_path", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{"intel", "use"}, "cgroup_include", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{"intel", "use"}, "cgroup_exclude", false, plugin.SetDefaultString(""))
+	policy.AddNewIntRule([]string{"intel", "use"}, "cgroup_max_depth", false, plugin.SetDefaultInt(4), plugin.SetMinInt(1))
+	policy.AddNewStringRule([]string{"intel", "use"}, "unit_include", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{"intel", "use"}, "unit_exclude", false, plugin.SetDefaultString(""))
+	policy.AddNewBoolRule([]string{"intel", "use"}, "process_scanner", false, plugin.SetDefaultBool(false))
+	policy.AddNewIntRule([]string{"intel", "use"}, "process_top_n", false, plugin.SetDefaultInt(5), plugin.SetMinInt(1))
+	policy.AddNewStringRule([]string{"intel", "use"}, "process_scan_interval", false, plugin.SetDefaultString("5s"))
+	policy.AddNewStringRule([]string{"intel", "use"}, "passwd_path", false, plugin.SetDefaultString("etc/passwd"))
+	policy.AddNewStringRule([]string{"intel", "use"}, "kubelet_pods_path", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{"intel", "use"}, "container_state_path", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{"intel", "use"}, "tags", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{"intel", "use"}, "tags_drop", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{"intel", "use"}, "tag_providers", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{"intel", "use"}, "storage_include", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{"intel", "use"}, "storage_exclude", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{"intel", "use"}, "network_include", false, plugin.SetDefaultString(""))
+	policy.AddNewStringRule([]string{"intel", "use"}, "network_exclude", false, plugin.SetDefaultString(""))
+	policy.AddNewBoolRule([]string{"intel", "use"}, "physical_devices_only", false, plugin.SetDefaultBool(false))
 	return *policy, nil
 }
@@ -26,21 +26,17 @@ import (
 	"path/filepath"
 	"regexp"
 	"testing"
+	"time"
 
-	"github.com/intelsdi-x/snap/control/plugin"
-	"github.com/intelsdi-x/snap/control/plugin/cpolicy"
-	"github.com/intelsdi-x/snap/core"
-	"github.com/intelsdi-x/snap/core/cdata"
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
 
 func TestUsePlugin(t *testing.T) {
-	Convey("Meta should return metadata for the plugin", t, func() {
-		meta := Meta()
-		So(meta.Name, ShouldResemble, name)
-		So(meta.Version, ShouldResemble, version)
-		So(meta.Type, ShouldResemble, plugin.CollectorPluginType)
+	Convey("Plugin should expose its name and version", t, func() {
+		So(PluginName, ShouldResemble, "use")
+		So(PluginVersion, ShouldResemble, 1)
 	})
 
 	Convey("Create Use Collector", t, func() {
@@ -56,114 +52,534 @@ func TestUsePlugin(t *testing.T) {
 			Convey("So config policy should not be nil", func() {
 				So(configPolicy, ShouldNotBeNil)
 			})
-			Convey("So config policy should be a cpolicy.ConfigPolicy", func() {
-				So(configPolicy, ShouldHaveSameTypeAs, &cpolicy.ConfigPolicy{})
+			Convey("So config policy should be a plugin.ConfigPolicy", func() {
+				So(configPolicy, ShouldHaveSameTypeAs, plugin.ConfigPolicy{})
 			})
 		})
 	})
 
 	Convey("Get Metrics ", t, func() {
 		useCol := NewUseCollector()
-		var cfg = plugin.ConfigType{}
+		var cfg = plugin.Config{"proc_path": "proc", "sys_path": "sys"}
 
 		Convey("So should return 26 types of metrics", func() {
 			metrics, err := useCol.GetMetricTypes(cfg)
 			So(len(metrics), ShouldBeGreaterThan, 13)
 			So(err, ShouldBeNil)
 		})
+		Convey("So should discover storage devices from sys_path", func() {
+			metrics, err := useCol.GetMetricTypes(cfg)
+			So(err, ShouldBeNil)
+			disks := map[string]bool{}
+			for _, m := range metrics {
+				if m.Namespace.Strings()[2] == "storage" && len(m.Namespace) == 5 {
+					disks[m.Namespace.Strings()[3]] = true
+				}
+			}
+			So(disks, ShouldResemble, map[string]bool{"sda": true, "sr0": true, "loop0": true, "ram0": true, "dm-0": true, "dm-1": true, "dm-2": true})
+		})
+		Convey("So should apply device filters", func() {
+			filtered := NewUseCollector()
+			metrics, err := filtered.GetMetricTypes(plugin.Config{
+				"proc_path":             "proc",
+				"sys_path":              "sys",
+				"storage_exclude":       "^sr",
+				"physical_devices_only": true,
+			})
+			So(err, ShouldBeNil)
+			devices := map[string]bool{}
+			for _, m := range metrics {
+				if len(m.Namespace) == 5 && m.Namespace.Strings()[2] != "compute" {
+					devices[m.Namespace.Strings()[3]] = true
+				}
+			}
+			So(devices, ShouldResemble, map[string]bool{"sda": true, "eth0": true})
+		})
+		Convey("So should fail with invalid device filter", func() {
+			filtered := NewUseCollector()
+			_, err := filtered.GetMetricTypes(plugin.Config{"proc_path": "proc", "sys_path": "sys", "storage_include": "["})
+			So(err, ShouldNotBeNil)
+		})
 		Convey("So should check namespace", func() {
 			metrics, err := useCol.GetMetricTypes(cfg)
-			vcpuNamespace := metrics[0].Namespace().String()
+			vcpuNamespace := metrics[0].Namespace.String()
 			vcpu := regexp.MustCompile(`^/intel/use/compute/utilization`)
 			So(true, ShouldEqual, vcpu.MatchString(vcpuNamespace))
 			So(err, ShouldBeNil)
 
-			vcpuNamespace1 := metrics[1].Namespace().String()
+			vcpuNamespace1 := metrics[1].Namespace.String()
 			vcpu1 := regexp.MustCompile(`^/intel/use/compute/saturation`)
 			So(true, ShouldEqual, vcpu1.MatchString(vcpuNamespace1))
 			So(err, ShouldBeNil)
 		})
 
 	})
+	Convey("Snapshots", t, func() {
+		useCol := NewUseCollector()
+		ns := plugin.NewNamespace("intel", "use", "compute", "utilization")
+		Convey("So first collection should report warm-up", func() {
+			_, _, ok := useCol.swapSnapshot(ns, map[string]int64{"idle": 1})
+			So(ok, ShouldBeFalse)
+		})
+		Convey("So next collection should return previous counters", func() {
+			useCol.swapSnapshot(ns, map[string]int64{"idle": 1})
+			time.Sleep(time.Millisecond)
+			last, elapsed, ok := useCol.swapSnapshot(ns, map[string]int64{"idle": 2})
+			So(ok, ShouldBeTrue)
+			So(last.counters["idle"], ShouldEqual, 1)
+			So(elapsed, ShouldBeGreaterThan, 0)
+		})
+	})
+	Convey("Collect process metrics", t, func() {
+		useCol := NewUseCollector()
+		cfg := plugin.Config{"proc_path": "proc", "sys_path": "sys", "root_path": "rootfs", "process_scanner": true, "process_top_n": int64(2)}
+		mts, err := useCol.GetMetricTypes(cfg)
+		So(err, ShouldBeNil)
+		processes := 0
+		for _, m := range mts {
+			if m.Namespace.Strings()[2] == "process" {
+				processes++
+			}
+		}
+		So(processes, ShouldEqual, 2*len(processMetrics))
+		collect, err := useCol.CollectMetrics([]plugin.Metric{
+			{Namespace: plugin.NewNamespace("intel", "use", "process", "rss", "1"), Config: cfg},
+			{Namespace: plugin.NewNamespace("intel", "use", "process", "rss", "9"), Config: cfg},
+		})
+		So(err, ShouldBeNil)
+		So(collect[0].Data, ShouldResemble, 524288.0*1024)
+		So(collect[0].Tags["comm"], ShouldEqual, "java")
+		So(collect[0].Tags["pid"], ShouldEqual, "1422")
+		So(collect[0].Tags["user"], ShouldEqual, "1001")
+		So(collect[1].Data, ShouldResemble, 0.0)
+		So(collect[1].Tags, ShouldNotContainKey, "pid")
+	})
+	Convey("Collect process rates", t, func() {
+		useCol := NewUseCollector()
+		cfg := plugin.Config{"proc_path": "proc", "sys_path": "sys", "root_path": "rootfs", "process_scanner": true, "process_scan_interval": "1h"}
+		metrics := []plugin.Metric{
+			{Namespace: plugin.NewNamespace("intel", "use", "process", "cpu_utilization", "1"), Config: cfg},
+			{Namespace: plugin.NewNamespace("intel", "use", "process", "read_bytes_per_sec", "1"), Config: cfg},
+			{Namespace: plugin.NewNamespace("intel", "use", "process", "rss", "1"), Config: cfg},
+		}
+		Convey("So first collection should publish only resident memory", func() {
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(len(collect), ShouldEqual, 1)
+			So(collect[0].Namespace.String(), ShouldEqual, "/intel/use/process/rss/1")
+		})
+		Convey("So next collection should publish rates", func() {
+			useCol.CollectMetrics(metrics)
+			// force the next scan
+			useCol.ProcessScanInterval = 0
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(len(collect), ShouldEqual, 3)
+			So(collect[0].Namespace.String(), ShouldEqual, "/intel/use/process/cpu_utilization/1")
+		})
+	})
+	Convey("Collect user metrics", t, func() {
+		useCol := NewUseCollector()
+		cfg := plugin.Config{"proc_path": "proc", "sys_path": "sys", "root_path": "rootfs", "process_scanner": true}
+		mts, err := useCol.GetMetricTypes(cfg)
+		So(err, ShouldBeNil)
+		users := map[string]bool{}
+		for _, m := range mts {
+			if m.Namespace.Strings()[2] == "user" {
+				users[m.Namespace.Strings()[3]] = true
+			}
+		}
+		So(users, ShouldResemble, map[string]bool{"root": true, "alice": true, "1001": true})
+		metrics := []plugin.Metric{
+			{Namespace: plugin.NewNamespace("intel", "use", "user", "root", "rss"), Config: cfg},
+			{Namespace: plugin.NewNamespace("intel", "use", "user", "alice", "cpu_seconds"), Config: cfg},
+		}
+		// discovery does not scan processes, the first collection is warm-up
+		collect, err := useCol.CollectMetrics(metrics)
+		So(err, ShouldBeNil)
+		So(len(collect), ShouldEqual, 1)
+		So(collect[0].Data, ShouldResemble, (9216.0+6144.0)*1024)
+		So(collect[0].Tags["uid"], ShouldEqual, "0")
+		// force the next scan
+		useCol.ProcessScanInterval = 0
+		collect, err = useCol.CollectMetrics(metrics)
+		So(err, ShouldBeNil)
+		So(len(collect), ShouldEqual, 2)
+		So(collect[1].Data, ShouldResemble, 0.0)
+		So(collect[1].Tags["user"], ShouldEqual, "alice")
+	})
 	Convey("Collect Metrics", t, func() {
 		useCol := &Use{}
 
-		cfgNode := cdata.NewNode()
-
 		pwd, err := os.Getwd()
-		procPath = filepath.Join(pwd, "proc")
-		diskStatPath = filepath.Join(procPath, "diskstats")
-		cpuStatPath = filepath.Join(procPath, "stat")
-		loadAvgPath = filepath.Join(procPath, "loadavg")
-		memInfoPath = filepath.Join(procPath, "meminfo")
-		vmStatPath = filepath.Join(procPath, "vmstat")
 		So(err, ShouldBeNil)
+		cfg := plugin.Config{
+			"proc_path": filepath.Join(pwd, "proc"),
+			"sys_path":  filepath.Join(pwd, "sys"),
+			"root_path": filepath.Join(pwd, "rootfs"),
+		}
+		Convey("So should tag metrics with measured host identity", func() {
+			metrics := []plugin.Metric{{
+				Namespace: plugin.NewNamespace("intel", "use", "compute", "errors"),
+				Config:    cfg,
+			}}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(collect[0].Tags["hostname"], ShouldEqual, "node-01")
+			So(collect[0].Tags["platform"], ShouldEqual, "ubuntu")
+		})
 		Convey("So should get memory saturation metrics", func() {
-			metrics := []plugin.MetricType{{
-				Namespace_: core.NewNamespace("intel", "use", "memory", "saturation"),
-				Config_:    cfgNode,
+			metrics := []plugin.Metric{{
+				Namespace: plugin.NewNamespace("intel", "use", "memory", "saturation"),
+				Config:    cfg,
 			}}
 			collect, err := useCol.CollectMetrics(metrics)
 			So(err, ShouldBeNil)
-			So(collect[0].Data_, ShouldNotBeNil)
+			// the first collection of delta metrics is warm-up
+			So(len(collect), ShouldResemble, 0)
+			collect, err = useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(collect[0].Data, ShouldNotBeNil)
 			var expectedType float64
-			So(collect[0].Data_, ShouldHaveSameTypeAs, expectedType)
+			So(collect[0].Data, ShouldHaveSameTypeAs, expectedType)
 			So(len(collect), ShouldResemble, 1)
 		})
 		Convey("So should get memory utilization metrics", func() {
-			metrics := []plugin.MetricType{{
-				Namespace_: core.NewNamespace("intel", "use", "memory", "utilization"),
-				Config_:    cfgNode,
+			metrics := []plugin.Metric{{
+				Namespace: plugin.NewNamespace("intel", "use", "memory", "utilization"),
+				Config:    cfg,
 			}}
 			collect, err := useCol.CollectMetrics(metrics)
 			So(err, ShouldBeNil)
-			So(collect[0].Data_, ShouldNotBeNil)
+			So(collect[0].Data, ShouldNotBeNil)
 			var expectedType float64
-			So(collect[0].Data_, ShouldHaveSameTypeAs, expectedType)
+			So(collect[0].Data, ShouldHaveSameTypeAs, expectedType)
+			So(len(collect), ShouldResemble, 1)
+		})
+		Convey("So should get memory cached metrics", func() {
+			metrics := []plugin.Metric{{
+				Namespace: plugin.NewNamespace("intel", "use", "memory", "cached"),
+				Config:    cfg,
+			}}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(collect[0].Data, ShouldResemble, 464232.0*1024)
+			So(len(collect), ShouldResemble, 1)
+		})
+		Convey("So should get pressure metrics", func() {
+			metrics := []plugin.Metric{{
+				Namespace: plugin.NewNamespace("intel", "use", "storage", "pressure", "some", "avg10"),
+				Config:    cfg,
+			}}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(collect[0].Data, ShouldResemble, 4.21)
 			So(len(collect), ShouldResemble, 1)
 		})
 		Convey("So should get compute utilization metrics", func() {
-			metrics := []plugin.MetricType{{
-				Namespace_: core.NewNamespace("intel", "use", "compute", "utilization"),
-				Config_:    cfgNode,
+			metrics := []plugin.Metric{{
+				Namespace: plugin.NewNamespace("intel", "use", "compute", "utilization"),
+				Config:    cfg,
 			}}
 			collect, err := useCol.CollectMetrics(metrics)
 			So(err, ShouldBeNil)
-			So(collect[0].Data_, ShouldNotBeNil)
+			// the first collection of delta metrics is warm-up
+			So(len(collect), ShouldResemble, 0)
+			collect, err = useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(collect[0].Data, ShouldNotBeNil)
 			So(len(collect), ShouldResemble, 1)
 		})
 		Convey("So should get compute saturation metrics", func() {
-			metrics := []plugin.MetricType{{
-				Namespace_: core.NewNamespace("intel", "use", "compute", "saturation"),
-				Config_:    cfgNode,
+			metrics := []plugin.Metric{{
+				Namespace: plugin.NewNamespace("intel", "use", "compute", "saturation"),
+				Config:    cfg,
 			}}
 			collect, err := useCol.CollectMetrics(metrics)
 			So(err, ShouldBeNil)
-			So(collect[0].Data_, ShouldNotBeNil)
+			So(collect[0].Data, ShouldNotBeNil)
 			var expectedType float64
-			So(collect[0].Data_, ShouldHaveSameTypeAs, expectedType)
+			So(collect[0].Data, ShouldHaveSameTypeAs, expectedType)
+			So(len(collect), ShouldResemble, 1)
+		})
+		Convey("So should get compute errors metrics", func() {
+			metrics := []plugin.Metric{{
+				Namespace: plugin.NewNamespace("intel", "use", "compute", "errors"),
+				Config:    cfg,
+			}}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(collect[0].Data, ShouldResemble, 2.0)
+			So(len(collect), ShouldResemble, 1)
+		})
+		Convey("So should get run queue saturation metrics", func() {
+			metrics := []plugin.Metric{
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "procs_running_per_cpu"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "procs_blocked_per_cpu"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "runnable_per_cpu"), Config: cfg},
+			}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(len(collect), ShouldResemble, 3)
+			So(collect[0].Data, ShouldResemble, 0.125)
+			So(collect[1].Data, ShouldResemble, 0.0)
+			So(collect[2].Data, ShouldResemble, 0.125)
+		})
+		Convey("So should get load average metrics", func() {
+			metrics := []plugin.Metric{
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "load15"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "load5_per_cpu"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "entities_total"), Config: cfg},
+			}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(len(collect), ShouldResemble, 3)
+			So(collect[0].Data, ShouldResemble, 0.05)
+			So(collect[1].Data, ShouldResemble, 0.01/8)
+			So(collect[2].Data, ShouldResemble, 287.0)
+		})
+		Convey("So should get cpu topology metrics of measured host", func() {
+			metrics := []plugin.Metric{
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "topology", "cpus_online"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "topology", "sockets"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "topology", "cores"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "topology", "threads_per_core"), Config: cfg},
+			}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(len(collect), ShouldResemble, 4)
+			So(collect[0].Data, ShouldResemble, 8.0)
+			So(collect[1].Data, ShouldResemble, 1.0)
+			So(collect[2].Data, ShouldResemble, 4.0)
+			So(collect[3].Data, ShouldResemble, 2.0)
+		})
+		Convey("So should get per cpu metrics", func() {
+			metrics := []plugin.Metric{
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "cpu", "3", "utilization"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "cpu", "3", "saturation"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "cpu", "3", "user"), Config: cfg},
+			}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			// the first collection of delta metrics is warm-up
+			So(len(collect), ShouldResemble, 0)
+			collect, err = useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(len(collect), ShouldResemble, 3)
+			for _, m := range collect {
+				So(m.Data, ShouldResemble, 0.0)
+			}
+		})
+		Convey("So should fail on unknown cpu", func() {
+			metrics := []plugin.Metric{{
+				Namespace: plugin.NewNamespace("intel", "use", "compute", "cpu", "64", "utilization"),
+				Config:    cfg,
+			}}
+			_, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So should report requested and failed metrics when all fail", func() {
+			metrics := []plugin.Metric{
+				{Namespace: plugin.NewNamespace("intel", "use", "storage", "sdz", "in_flight"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "cpu", "64", "utilization"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "collector", "failed_metrics"), Config: cfg},
+			}
+			_, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "Unable to collect any of 3 requested metrics, 2 failed")
+		})
+		Convey("So should return partial results when some metrics fail", func() {
+			metrics := []plugin.Metric{
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "errors"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "storage", "sdz", "in_flight"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "compute", "cpu", "64", "utilization"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "collector", "failed_metrics"), Config: cfg},
+			}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(len(collect), ShouldResemble, 2)
+			So(collect[0].Namespace.String(), ShouldEqual, "/intel/use/compute/errors")
+			So(collect[1].Namespace.String(), ShouldEqual, "/intel/use/collector/failed_metrics")
+			So(collect[1].Data, ShouldResemble, 2.0)
+			So(collect[1].Tags["hostname"], ShouldEqual, "node-01")
+		})
+		Convey("So should skip warm-up values without counting them as failed", func() {
+			metrics := []plugin.Metric{
+				{Namespace: plugin.NewNamespace("intel", "use", "storage", "sda", "utilization"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "collector", "failed_metrics"), Config: cfg},
+			}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(len(collect), ShouldResemble, 1)
+			So(collect[0].Data, ShouldResemble, 0.0)
+			collect, err = useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(len(collect), ShouldResemble, 2)
+			So(collect[0].Namespace.String(), ShouldEqual, "/intel/use/storage/sda/utilization")
+		})
+		Convey("So should report failed metrics while other metrics warm up", func() {
+			metrics := []plugin.Metric{
+				{Namespace: plugin.NewNamespace("intel", "use", "storage", "sda", "utilization"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "storage", "sdz", "utilization"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "collector", "failed_metrics"), Config: cfg},
+			}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(len(collect), ShouldResemble, 1)
+			So(collect[0].Namespace.String(), ShouldEqual, "/intel/use/collector/failed_metrics")
+			So(collect[0].Data, ShouldResemble, 1.0)
+		})
+		Convey("So should report no failed metrics", func() {
+			metrics := []plugin.Metric{
+				{Namespace: plugin.NewNamespace("intel", "use", "collector", "failed_metrics"), Config: cfg},
+			}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(collect[0].Data, ShouldResemble, 0.0)
+		})
+		Convey("So should get cgroup metrics", func() {
+			cgroup := cgroupNamespaceElement("system.slice/docker-0123.scope")
+			metrics := []plugin.Metric{
+				{Namespace: plugin.NewNamespace("intel", "use", "cgroup", cgroup, "memory", "utilization"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "cgroup", cgroup, "memory", "errors"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "cgroup", cgroup, "compute", "utilization"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "cgroup", "system.slice", "memory", "utilization"), Config: cfg},
+			}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			// the first collection of delta metrics is warm-up
+			So(len(collect), ShouldResemble, 3)
+			collect, err = useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(len(collect), ShouldResemble, 4)
+			So(collect[0].Data, ShouldResemble, 50.0)
+			So(collect[0].Tags["cgroup_path"], ShouldEqual, "/system.slice/docker-0123.scope")
+			So(collect[0].Tags["hostname"], ShouldEqual, "node-01")
+			So(collect[1].Data, ShouldResemble, 1.0)
+			So(collect[2].Data, ShouldResemble, 0.0)
+			So(collect[3].Data, ShouldBeGreaterThan, 0.0)
+		})
+		Convey("So should get systemd unit metrics", func() {
+			metrics := []plugin.Metric{
+				{Namespace: plugin.NewNamespace("intel", "use", "systemd", "sshd.service", "memory", "utilization"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "systemd", "sshd.service", "storage", "read_iops"), Config: cfg},
+			}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			// the first collection of delta metrics is warm-up
 			So(len(collect), ShouldResemble, 1)
+			collect, err = useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(len(collect), ShouldResemble, 2)
+			So(collect[0].Data, ShouldResemble, 50.0)
+			So(collect[0].Tags["unit"], ShouldEqual, "sshd.service")
+			So(collect[0].Tags["slice"], ShouldEqual, "system.slice")
+			So(collect[1].Data, ShouldResemble, 0.0)
+		})
+		Convey("So should fail on process metrics when scanner is disabled", func() {
+			metrics := []plugin.Metric{
+				{Namespace: plugin.NewNamespace("intel", "use", "process", "rss", "1"), Config: cfg},
+				{Namespace: plugin.NewNamespace("intel", "use", "user", "root", "rss"), Config: cfg},
+			}
+			_, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("So should fail on unknown cgroup metric", func() {
+			metrics := []plugin.Metric{
+				{Namespace: plugin.NewNamespace("intel", "use", "cgroup", "system.slice", "memory", "saturation"), Config: cfg},
+			}
+			_, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldNotBeNil)
 		})
 		Convey("So should get disk utilization metrics", func() {
-			metrics := []plugin.MetricType{{
-				Namespace_: core.NewNamespace("intel", "use", "storage", "sda", "utilization"),
-				Config_:    cfgNode,
+			metrics := []plugin.Metric{{
+				Namespace: plugin.NewNamespace("intel", "use", "storage", "sda", "utilization"),
+				Config:    cfg,
 			}}
 			collect, err := useCol.CollectMetrics(metrics)
 			So(err, ShouldBeNil)
-			So(collect[0].Data_, ShouldNotBeNil)
+			// the first collection of delta metrics is warm-up
+			So(len(collect), ShouldResemble, 0)
+			collect, err = useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(collect[0].Data, ShouldNotBeNil)
 			So(len(collect), ShouldResemble, 1)
+			So(collect[0].Tags["device_type"], ShouldEqual, "sd")
+			So(collect[0].Tags["model"], ShouldEqual, "ST1000DM003-1SB1")
+			So(collect[0].Tags["hostname"], ShouldEqual, "node-01")
 		})
 		Convey("So should get disk saturation metrics", func() {
-			metrics := []plugin.MetricType{{
-				Namespace_: core.NewNamespace("intel", "use", "storage", "sda", "saturation"),
-				Config_:    cfgNode,
+			metrics := []plugin.Metric{{
+				Namespace: plugin.NewNamespace("intel", "use", "storage", "sda", "saturation"),
+				Config:    cfg,
+			}}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			// the first collection of delta metrics is warm-up
+			So(len(collect), ShouldResemble, 0)
+			collect, err = useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(collect[0].Data, ShouldNotBeNil)
+			var expectedType float64
+			So(collect[0].Data, ShouldHaveSameTypeAs, expectedType)
+			So(len(collect), ShouldResemble, 1)
+		})
+		Convey("So should get disk read iops metrics", func() {
+			metrics := []plugin.Metric{{
+				Namespace: plugin.NewNamespace("intel", "use", "storage", "sda", "read_iops"),
+				Config:    cfg,
+			}}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			// the first collection of delta metrics is warm-up
+			So(len(collect), ShouldResemble, 0)
+			collect, err = useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(collect[0].Data, ShouldResemble, 0.0)
+			So(len(collect), ShouldResemble, 1)
+		})
+		Convey("So should get disk in flight metrics", func() {
+			metrics := []plugin.Metric{{
+				Namespace: plugin.NewNamespace("intel", "use", "storage", "sda", "in_flight"),
+				Config:    cfg,
+			}}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(collect[0].Data, ShouldResemble, 0.0)
+			So(len(collect), ShouldResemble, 1)
+		})
+		Convey("So should get network utilization metrics", func() {
+			metrics := []plugin.Metric{{
+				Namespace: plugin.NewNamespace("intel", "use", "network", "eth0", "utilization"),
+				Config:    cfg,
 			}}
 			collect, err := useCol.CollectMetrics(metrics)
 			So(err, ShouldBeNil)
-			So(collect[0].Data_, ShouldNotBeNil)
+			// the first collection of delta metrics is warm-up
+			So(len(collect), ShouldResemble, 0)
+			collect, err = useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(collect[0].Data, ShouldNotBeNil)
+			var expectedType float64
+			So(collect[0].Data, ShouldHaveSameTypeAs, expectedType)
+			So(len(collect), ShouldResemble, 1)
+		})
+		Convey("So should get network saturation metrics", func() {
+			metrics := []plugin.Metric{{
+				Namespace: plugin.NewNamespace("intel", "use", "network", "eth0", "saturation"),
+				Config:    cfg,
+			}}
+			collect, err := useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			// the first collection of delta metrics is warm-up
+			So(len(collect), ShouldResemble, 0)
+			collect, err = useCol.CollectMetrics(metrics)
+			So(err, ShouldBeNil)
+			So(collect[0].Data, ShouldNotBeNil)
 			var expectedType float64
-			So(collect[0].Data_, ShouldHaveSameTypeAs, expectedType)
+			So(collect[0].Data, ShouldHaveSameTypeAs, expectedType)
+			So(collect[0].Tags["kind"], ShouldEqual, "physical")
+			So(collect[0].Tags["driver"], ShouldEqual, "ixgbe")
 			So(len(collect), ShouldResemble, 1)
 		})
 	})
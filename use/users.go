@@ -0,0 +1,134 @@
+package use
+
+import (
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+	"github.com/pkg/errors"
+)
+
+// userMetrics are names of resource usage metrics aggregated per user,
+// rss is a gauge while others are amounts consumed during collection interval
+var userMetrics = []string{"cpu_seconds", "rss", "read_bytes", "write_bytes"}
+
+// UserStat contains resource usage of all processes of a user
+type UserStat struct {
+	UID  string
+	User string
+	// Values are keyed by userMetrics
+	Values map[string]float64
+}
+
+// userStats sums usage of processes between two latest scans per user,
+// usage of children reaped by a process is accounted to its user
+func userStats(scan *processScan) map[string]*UserStat {
+	users := map[string]*UserStat{}
+	for _, p := range scan.stats {
+		stat, ok := users[p.User]
+		if !ok {
+			stat = &UserStat{UID: p.UID, User: p.User, Values: map[string]float64{"cpu_seconds": 0, "rss": 0, "read_bytes": 0, "write_bytes": 0}}
+			users[p.User] = stat
+		}
+		usage := scan.usage[p.PID]
+		stat.Values["cpu_seconds"] += float64(usage.cpu) / clockTicks
+		stat.Values["rss"] += p.Values["rss"]
+		stat.Values["read_bytes"] += float64(usage.readBytes)
+		stat.Values["write_bytes"] += float64(usage.writeBytes)
+	}
+	return users
+}
+
+// getUserMetricTypes returns metrics of users which own processes at the
+// time of discovery, processes are listed without scanning them, so the
+// first collection does not report usage since discovery
+func (u *Use) getUserMetricTypes() []plugin.Metric {
+	var mts []plugin.Metric
+	samples, err := readProcesses(u.ProcPath)
+	if err != nil {
+		log.Infof("Skipping user metrics: %s", err.Error())
+		return mts
+	}
+	users, err := readPasswd(u.PasswdPath)
+	if err != nil {
+		log.Warnf("Unable to resolve user names: %s", err.Error())
+	}
+	owners := map[string]bool{}
+	for _, sample := range samples {
+		owners[userName(users, sample.uid)] = true
+	}
+	names := []string{}
+	for name := range owners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, m := range userMetrics {
+			mts = append(mts, plugin.Metric{Namespace: plugin.NewNamespace("intel", "use", "user", name, m)})
+		}
+	}
+	return mts
+}
+
+func isUserMetric(name string) bool {
+	for _, m := range userMetrics {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *Use) userStat(ns plugin.Namespace) (*plugin.Metric, error) {
+	if len(ns) != 5 || !isUserMetric(ns.Strings()[4]) {
+		return nil, errors.Errorf("Unknown user stat namespace %v", ns)
+	}
+	if !u.processScanner {
+		return nil, errors.Errorf("Process scanner is disabled, set process_scanner to enable it")
+	}
+	scan, err := u.scanProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	user, name := ns.Strings()[3], ns.Strings()[4]
+	metric := &plugin.Metric{Namespace: ns, Data: 0.0, Tags: map[string]string{"user": user}}
+	stat, ok := userStats(scan)[user]
+	if !ok {
+		// user has no processes left
+		return metric, nil
+	}
+	metric.Tags["uid"] = stat.UID
+	metric.Data = stat.Values[name]
+
+	if name != "rss" && scan.elapsed == 0 {
+		// usage of the first scan is unknown
+		log.Debugf("No previous process scan for %s, skipping warm-up value", ns.String())
+		u.markWarmUp(ns)
+		return metric, nil
+	}
+
+	// usage of a scan is reported only once, so collecting more often than
+	// processes are scanned does not charge the same usage twice
+	if name != "rss" && !u.reportScan(ns, scan) {
+		metric.Data = 0.0
+	}
+	return metric, nil
+}
+
+// reportScan records scan as reported for namespace and returns false when
+// it was already reported
+func (u *Use) reportScan(ns plugin.Namespace, scan *processScan) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.reportedScans == nil {
+		u.reportedScans = map[string]time.Time{}
+	}
+	if u.reportedScans[ns.String()].Equal(scan.timestamp) {
+		return false
+	}
+	u.reportedScans[ns.String()] = scan.timestamp
+	return true
+}
@@ -0,0 +1,92 @@
+//
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package use
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jpra1113/snap-plugin-lib-go/v1/plugin"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUserStats(t *testing.T) {
+	Convey("Usage of processes should be summed per user", t, func() {
+		scan := &processScan{
+			stats: []ProcessStat{
+				{PID: "1", UID: "0", User: "root", Values: map[string]float64{"rss": 100}},
+				{PID: "2", UID: "0", User: "root", Values: map[string]float64{"rss": 50}},
+				{PID: "3", UID: "1000", User: "alice", Values: map[string]float64{"rss": 10}},
+			},
+			usage: map[string]processUsage{
+				"1": {cpu: 500, readBytes: 100},
+				"2": {cpu: 250, writeBytes: 200},
+			},
+		}
+		users := userStats(scan)
+		So(len(users), ShouldEqual, 2)
+		So(users["root"].UID, ShouldEqual, "0")
+		So(users["root"].Values, ShouldResemble, map[string]float64{"cpu_seconds": 7.5, "rss": 150, "read_bytes": 100, "write_bytes": 200})
+		So(users["alice"].Values, ShouldResemble, map[string]float64{"cpu_seconds": 0, "rss": 10, "read_bytes": 0, "write_bytes": 0})
+	})
+	Convey("Usage of a scan should be reported only once", t, func() {
+		u := &Use{ProcPath: "proc", PasswdPath: "rootfs/etc/passwd", ProcessScanInterval: time.Hour, processScanner: true}
+		u.processes = &processScan{
+			timestamp: time.Now(),
+			elapsed:   time.Minute,
+			stats:     []ProcessStat{{PID: "1", UID: "0", User: "root", Values: map[string]float64{"rss": 100}}},
+			usage:     map[string]processUsage{"1": {cpu: 500}},
+		}
+		cpu := plugin.NewNamespace("intel", "use", "user", "root", "cpu_seconds")
+		rss := plugin.NewNamespace("intel", "use", "user", "root", "rss")
+		for i := 0; i < 2; i++ {
+			metric, err := u.userStat(cpu)
+			So(err, ShouldBeNil)
+			So(metric.Tags, ShouldResemble, map[string]string{"user": "root", "uid": "0"})
+			if i == 0 {
+				So(metric.Data, ShouldResemble, 5.0)
+			} else {
+				So(metric.Data, ShouldResemble, 0.0)
+			}
+			metric, err = u.userStat(rss)
+			So(err, ShouldBeNil)
+			So(metric.Data, ShouldResemble, 100.0)
+		}
+		metric, err := u.userStat(plugin.NewNamespace("intel", "use", "user", "bob", "rss"))
+		So(err, ShouldBeNil)
+		So(metric.Data, ShouldResemble, 0.0)
+		_, err = u.userStat(plugin.NewNamespace("intel", "use", "user", "root", "major_faults"))
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Usage of the first scan should warm up", t, func() {
+		u := &Use{ProcPath: "proc", PasswdPath: "rootfs/etc/passwd", ProcessScanInterval: time.Hour, processScanner: true}
+		cpu := plugin.NewNamespace("intel", "use", "user", "root", "cpu_seconds")
+		rss := plugin.NewNamespace("intel", "use", "user", "root", "rss")
+		_, err := u.userStat(cpu)
+		So(err, ShouldBeNil)
+		So(u.warmingUp(cpu), ShouldBeTrue)
+		_, err = u.userStat(rss)
+		So(err, ShouldBeNil)
+		So(u.warmingUp(rss), ShouldBeFalse)
+	})
+}
@@ -2,14 +2,11 @@ package use
 
 import (
 	"bufio"
-	"bytes"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
-	"github.com/shirou/gopsutil/host"
 )
 
 func readLines(filename string) ([]string, error) {
@@ -39,14 +36,13 @@ func readLines(filename string) ([]string, error) {
 
 }
 
-func run(cmd string, args []string) ([]byte, error) {
-	command := exec.Command(cmd, args...)
-	var b bytes.Buffer
-	command.Stdout = &b
-	if err := command.Run(); err != nil {
-		return nil, errors.Errorf("Failed to run cmd %s: %s", cmd, err.Error())
+// readString returns first line of file without surrounding whitespace
+func readString(filename string) (string, error) {
+	lines, err := readLines(filename)
+	if err != nil {
+		return "", err
 	}
-	return b.Bytes(), nil
+	return strings.TrimSpace(lines[0]), nil
 }
 
 func readInt(filename string) (int64, error) {
@@ -73,22 +69,25 @@ func readInt(filename string) (int64, error) {
 	return i, nil
 }
 
-func hostTags() (map[string]string, error) {
-	tags := make(map[string]string)
-
-	hostInfo, err := host.Info()
+func readHexInt(filename string) (int64, error) {
+	lines, err := readLines(filename)
 	if err != nil {
-		return tags, errors.Errorf("Unable to get host info: %s", err.Error())
+		return 0, err
 	}
 
-	tags["hostname"] = hostInfo.Hostname
-	tags["os"] = hostInfo.OS
-	tags["platform"] = hostInfo.Platform
-	tags["platform_family"] = hostInfo.PlatformFamily
-	tags["platform_version"] = hostInfo.PlatformVersion
-	tags["virtualization_role"] = hostInfo.VirtualizationRole
-	tags["virtualization_system"] = hostInfo.VirtualizationSystem
+	trimmedLine := strings.TrimPrefix(strings.TrimSpace(lines[0]), "0x")
+	i, err := strconv.ParseInt(trimmedLine, 16, 64)
+	if err != nil {
+		return 0, errors.Errorf("Unable to parse hex int from line %s: %s", trimmedLine, err.Error())
+	}
 
-	return tags, nil
+	return i, nil
+}
 
+// ratio returns a/b or 0 when b is 0
+func ratio(a, b float64) float64 {
+	if b == 0 {
+		return 0.0
+	}
+	return a / b
 }